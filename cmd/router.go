@@ -2,17 +2,30 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	ag "exate-dora-router/internal/apigator"
+	"exate-dora-router/internal/audit"
 	cfg "exate-dora-router/internal/config"
 	gLogger "exate-dora-router/internal/logger"
+	"exate-dora-router/internal/metrics"
+	"exate-dora-router/internal/tracing"
 	"flag"
 	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	ginzap "github.com/gin-contrib/zap"
@@ -21,19 +34,69 @@ import (
 
 var (
 	// Router config struct for obtaining the configuration parameters of this
-	// router and the list of targets for broadcasting the incoming requests
-	router *ag.APIGatorRouter
+	// router and the list of targets for broadcasting the incoming requests.
+	// It's replaced on every configuration reload, so every request must load
+	// it once and keep using the same snapshot until it finishes
+	activeRouter atomic.Pointer[ag.APIGatorRouter]
 
 	// logger for the APIGatorDoraRouter. It's used across all this binary
 	logger *zap.Logger
 
 	// gRouter object for defining the GinFramework router instance for the HTTP server
 	gRouter *gin.Engine
+
+	// auditLog writes the record of every routing decision. nil when auditing
+	// is disabled
+	auditLog *audit.Logger
+
+	// errResponseSelected is the cause of cancelling the requests still
+	// in-flight once a response is selected
+	errResponseSelected = errors.New("response already selected")
 )
 
 const (
 	// URL path for the Healthcheck handler. This was included for the K8s probes.
 	healthcheckPath = "/healthz"
+	// URL paths for the K8s liveness and readiness probes
+	livenessPath  = "/livez"
+	readinessPath = "/readyz"
+	// URL path for the health of every APIGator target
+	targetsHealthPath = "/healthz/targets"
+	// URL path for the state of the Access Token of every APIGator target
+	tokenStatusPath = "/status/tokens"
+	// URL path for the state of the circuit breaker of every APIGator target
+	breakerStatusPath = "/status/breakers"
+	// URL path for the Prometheus metrics
+	metricsPath = "/metrics"
+
+	// HTTP header for the requester to define the fields it's interested in
+	// as a comma separated list of JSONPath-like selectors
+	requiredFieldsHeader = "X-Dora-Required-Fields"
+	// HTTP header identifying the request. It's generated if the requester
+	// doesn't send it, and it's always returned on the response
+	requestIDHeader = "X-Request-ID"
+	// HTTP headers describing the decision, when 'decision_headers' is enabled
+	selectedTargetHeader = "X-Dora-Selected-Target"
+	scoreHeader          = "X-Dora-Score"
+	candidatesHeader     = "X-Dora-Candidates"
+	// HTTP header for the requester to get the explanation of the decision,
+	// when 'allow_explain' is enabled
+	explainHeader = "X-Dora-Explain"
+	// Key of the response body holding the explanation of the decision
+	explanationKey = "explanation"
+
+	// Reason label of the targets skipped because of their circuit breaker
+	metricsSkipCircuitOpen = "circuit_open"
+	// Reason label of the targets skipped because they don't serve the
+	// jurisdiction or manifest of the request
+	metricsSkipJurisdiction = "jurisdiction"
+	// Reason label of the targets blocked by the data-residency policy
+	metricsSkipResidency = "residency"
+
+	// Subcommand for checking the configuration file without starting the router
+	validateConfigCommand = "validate-config"
+	// Subcommand for checking the hash chain of the audit files
+	verifyAuditCommand = "verify-audit"
 )
 
 // Init function for pre-configuring the global vars for the router
@@ -51,13 +114,67 @@ func init() {
 	gRouter.Use(ginzap.Ginzap(logger, time.RFC3339, true))
 }
 
-// healthcheckHandler manages the incoming connections on the path "/healthz"
-// for evaulating K8s Startup/Readiness/Liveness probes
+// healthcheckHandler manages the incoming connections on the paths "/healthz"
+// and "/livez" for evaulating K8s Startup/Liveness probes. It only reports
+// that the process is alive
 func healthcheckHandler(c *gin.Context) {
-	logger.Info("Healthcheck probe requested")
+	logger.Debug("Healthcheck probe requested")
 	c.JSON(http.StatusOK, gin.H{"health_status": "ok"})
 }
 
+// readinessHandler manages the K8s Readiness probe. The router is ready when
+// at least 'min_healthy_targets' APIGator targets are healthy
+func readinessHandler(c *gin.Context) {
+	router := activeRouter.Load()
+	healthy := router.HealthyTargets()
+	status := http.StatusOK
+	if healthy < router.MinHealthyTargets {
+		logger.Warn("Router not ready. Not enough healthy APIGator targets",
+			zap.Int("healthy_targets", healthy),
+			zap.Int("min_healthy_targets", router.MinHealthyTargets),
+		)
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{
+		"ready":               status == http.StatusOK,
+		"healthy_targets":     healthy,
+		"min_healthy_targets": router.MinHealthyTargets,
+	})
+}
+
+// targetsHealthHandler returns the result of the last health check of every
+// APIGatorTarget
+func targetsHealthHandler(c *gin.Context) {
+	router := activeRouter.Load()
+	targets := make(map[string]ag.HealthState, len(router.APIGatorTargets))
+	for _, apiGator := range router.APIGatorTargets {
+		targets[apiGator.Name] = apiGator.Health.State()
+	}
+	c.JSON(http.StatusOK, gin.H{"targets": targets})
+}
+
+// tokenStatusHandler returns the state of the Access Token of every
+// APIGatorTarget. The tokens themselves are never exposed
+func tokenStatusHandler(c *gin.Context) {
+	router := activeRouter.Load()
+	tokens := make(map[string]ag.TokenState, len(router.APIGatorTargets))
+	for _, apiGator := range router.APIGatorTargets {
+		tokens[apiGator.Name] = apiGator.Tokens.State()
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+// breakerStatusHandler returns the state of the circuit breaker of every
+// APIGatorTarget
+func breakerStatusHandler(c *gin.Context) {
+	router := activeRouter.Load()
+	breakers := make(map[string]ag.BreakerState, len(router.APIGatorTargets))
+	for _, apiGator := range router.APIGatorTargets {
+		breakers[apiGator.Name] = apiGator.Breaker.State()
+	}
+	c.JSON(http.StatusOK, gin.H{"breakers": breakers})
+}
+
 // forwardRequest is the main HTTP handler function for the APIGatorDoraRouter.
 // It takes the incoming requests with the data to process, and forwards it to
 // every APIGator target defined on the config.ini file.
@@ -65,8 +182,23 @@ func healthcheckHandler(c *gin.Context) {
 // from among all those received by the different targets, to return it to the
 // originating requester.
 func forwardRequest(c *gin.Context) {
+	// Identifying the request for the logs, the audit and the requester
+	id := requestID(c)
+	c.Header(requestIDHeader, id)
+
 	// Logging the origin IP of the requester
-	logger.Debug("Received Request", zap.String("origin", c.RemoteIP()))
+	logger.Debug("Received Request", zap.String("origin", c.RemoteIP()), zap.String("request_id", id))
+
+	// Configuration snapshot for the whole request, even if it's reloaded meanwhile
+	router := activeRouter.Load()
+
+	// Server span of the request, continuing the trace of the requester if
+	// it sent a 'traceparent' header
+	reqCtx, span := tracing.Start(tracing.Extract(c.Request.Context(), c.Request.Header), "forwardRequest",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("http.route", router.Path)),
+	)
+	defer span.End()
 
 	// Obtainning JSON body from request
 	var jsonData map[string]interface{}
@@ -75,98 +207,634 @@ func forwardRequest(c *gin.Context) {
 		return
 	}
 
+	// Encoding JSON request body once for every APIGator target
+	jsonBytes, err := json.Marshal(jsonData)
+	if err != nil {
+		logger.Error("Failed to marshal JSON", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	// Get 'restrictedText' field for each request. It will be used later for evaluating the response score
+	var restrictedText string
+	if jsonData != nil {
+		restrictedText = jsonData["restrictedText"].(string)
+	} else {
+		logger.Error("Incoming data JSON is empty!")
+		return
+	}
+
+	// Context shared by every forwarding thread. It's derived from the
+	// incoming request, so a requester disconnection aborts the requests
+	// still in-flight, as well as the router deadline or the selection of a
+	// response do. Only the last two are cancellations: a target not
+	// answering before the router deadline is a failure
+	ctx, cancel := context.WithCancelCause(reqCtx)
+	defer cancel(nil)
+	if router.Timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, router.Timeout*time.Second)
+		defer cancelTimeout()
+	}
+
+	// What happens with every target, for the audit record
+	outcomes := ag.NewOutcomes(router.APIGatorTargets)
+
+	// The data-residency policy can't permit any target without knowing the
+	// owner of the data, so those requests are rejected before the fan-out
+	if dataOwningCountryCode, _ := jsonData["dataOwningCountryCode"].(string); router.Residency != nil && strings.TrimSpace(dataOwningCountryCode) == "" {
+		for _, target := range router.APIGatorTargets {
+			outcomes.Skipped(target.Name, "missing dataOwningCountryCode")
+		}
+		rejectRequest(c, router, id, jsonData, jsonBytes, outcomes, http.StatusBadRequest,
+			"The data-residency policy requires the dataOwningCountryCode of the request as a string")
+		return
+	}
+
+	// Only the targets serving the jurisdiction and manifest of the request
+	// get it, unless none of them does and the fallback is to broadcast it
+	targets := selectTargets(router, jsonData, id, outcomes)
+	if len(targets) == 0 {
+		rejectRequest(c, router, id, jsonData, jsonBytes, outcomes, http.StatusUnprocessableEntity,
+			"No APIGator target serves the jurisdiction and manifest of the request")
+		return
+	}
+
+	// The data-residency policy removes the targets where the payload can't
+	// be sent. It's enforced before any request is forwarded
+	targets = enforceResidency(router, targets, jsonData, id, outcomes)
+	if len(targets) == 0 {
+		rejectRequest(c, router, id, jsonData, jsonBytes, outcomes, http.StatusForbidden,
+			"The data-residency policy doesn't permit forwarding the request to any APIGator target")
+		return
+	}
+
 	// Creating channel and WaitGroup for forwarding the request to the APIGatorTarget list in parallel
-	responseChan := make(chan ag.APIGatorResponse, len(router.APIGatorTargets))
+	responseChan := make(chan ag.APIGatorResponse, len(targets))
 	var wg sync.WaitGroup
 
 	// Forwarding to the list of APIGator instances simultaneously
-	for i, _ := range router.APIGatorTargets {
-		apiGator := router.APIGatorTargets[i]
-		logger.Debug("Forwarding request to APIGator instance", zap.String("apigator_target", apiGator.Name))
-		// Decoding JSON request body
-		jsonBytes, err := json.Marshal(jsonData)
-		if err != nil {
-			logger.Error("Failed to marshal JSON", zap.String("apigator_target", apiGator.Name), zap.Error(err))
-			return
+	for i, _ := range targets {
+		apiGator := targets[i]
+
+		// Targets with an open circuit breaker are skipped
+		if !apiGator.Breaker.Allow() {
+			logger.Warn("Skipping APIGator instance with open circuit breaker", zap.String("apigator_target", apiGator.Name), zap.String("request_id", id))
+			metrics.ObserveSkipped(apiGator.Name, metricsSkipCircuitOpen)
+			outcomes.Skipped(apiGator.Name, "circuit breaker open")
+			continue
 		}
+		logger.Debug("Forwarding request to APIGator instance", zap.String("apigator_target", apiGator.Name))
 
 		// Simultaneous forwarding on parallel. Creating one thread per APIGator target
 		wg.Add(1)
-
-		//TODO: remove sleep. It's just for testing
-		time.Sleep(1 * time.Second)
-
 		go func(id int, apiGator *ag.APIGatorTarget) {
 			logger.Debug("Launching Forwarding thread", zap.Int("id", id))
-			if err := apiGator.ForwardRequestToAPIGator(&wg, jsonBytes, responseChan); err != nil {
+			targetCtx, targetSpan := tracing.Start(ctx, "ForwardRequestToAPIGator",
+				trace.WithAttributes(attribute.String("apigator.target", apiGator.Name)),
+			)
+			err := apiGator.ForwardRequestToAPIGator(targetCtx, &wg, jsonBytes, responseChan)
+			cancelled := cancelledByRouter(ctx)
+			if cancelled {
+				logger.Debug("Request to APIGator cancelled", zap.String("apigator_target", apiGator.Name), zap.Error(context.Cause(ctx)))
+			} else if err != nil {
 				logger.Error("Failed to send request", zap.String("apigator_target", apiGator.Name), zap.String("request_body", string(jsonBytes)), zap.Error(err))
 			}
+			tracing.End(targetSpan, err)
+			apiGator.RecordResult(err, cancelled)
+			outcomes.Finished(apiGator.Name, err, cancelled)
 			wg.Done()
 		}(i, apiGator)
+	}
 
+	// Closes the channel once every thread has answered, so the responses can
+	// be processed as soon as they arrive
+	go func() {
+		wg.Wait()
+		close(responseChan)
+	}()
+
+	// Request information for evaluating the responses
+	evalCtx := ag.EvaluationContext{
+		RestrictedText: restrictedText,
+		Masking:        router.Masking,
+		MaxDepth:       router.MaxDepth,
+	}
+	if manifestName, ok := jsonData["manifestName"].(string); ok {
+		evalCtx.Schema = router.Schemas.Lookup(manifestName)
+	}
+	if header := c.GetHeader(requiredFieldsHeader); header != "" {
+		evalCtx.RequiredFields = strings.Split(header, ",")
 	}
-	// Wait for all requests to complete and closes the channel once every thread has answered
-	wg.Wait()
-	close(responseChan)
 
-	// Get 'restrictedText' field for each request. It will be used later for evaluating the response score
-	var restrictedText string
-	if jsonData != nil {
-		restrictedText = jsonData["restrictedText"].(string)
-	} else {
-		logger.Error("Incoming data JSON is empty!")
+	logger.Debug("Processing responses", zap.String("restricted_text", restrictedText))
+	resp := processResponses(ctx, router, responseChan, evalCtx, outcomes, jsonData["dataSet"].(string))
+	decision := outcomes.List()
+	var respBodyBytes []byte
+	if resp != nil {
+		if respBodyBytes, err = ioutil.ReadAll(resp.Response.Body); err != nil {
+			logger.Error("Failed to read selected response", zap.String("apigator_target", resp.Name), zap.Error(err))
+			resp = nil
+		}
+	}
+
+	// Audit record of the decision. The requester may be gone when it's
+	// written, so everything needed from the request is taken now
+	record := newAuditRecord(c, router, id, jsonData, jsonBytes)
+	if resp != nil {
+		record.SelectedTarget = resp.Name
+		record.ResponseSHA256 = audit.Digest(respBodyBytes)
+	}
+
+	// Cancelling the requests still in-flight and releasing the responses that
+	// arrive after the selection. The audit record is written once every
+	// target finished, so it includes the outcome of the cancelled requests
+	cancel(errResponseSelected)
+	for r := range responseChan {
+		outcomes.Responded(&r)
+		r.Response.Body.Close()
+	}
+	record.Targets = auditTargets(outcomes.List())
+	if !writeAuditRecord(c, id, record) {
 		return
 	}
 
-	logger.Debug("Processing responses", zap.String("restricted_text", restrictedText))
-	resp := processResponses(responseChan, restrictedText, jsonData["dataSet"].(string))
-	if resp == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"status": "No response"})
+	// Nobody is waiting for the response if the requester is gone
+	if err := c.Request.Context().Err(); err != nil {
+		logger.Warn("Requester disconnected before receiving the response", zap.String("origin", c.RemoteIP()), zap.Error(err))
 		return
 	}
-	respBodyBytes, err := ioutil.ReadAll(resp.Response.Body)
-	if err != nil {
+
+	// Explaining the decision to the requester, only if it's allowed
+	explain := strings.EqualFold(c.GetHeader(explainHeader), "true")
+	if explain && !router.AllowExplain {
+		logger.Warn("Explanation requested but not allowed by 'allow_explain'", zap.String("origin", c.RemoteIP()), zap.String("request_id", id))
+		explain = false
+	}
+	if router.DecisionHeaders {
+		setDecisionHeaders(c, resp, decision)
+	}
+
+	if resp == nil {
+		body := gin.H{"status": "No response"}
+		if explain {
+			body[explanationKey] = newExplanation(router, nil, decision)
+		}
+		c.JSON(http.StatusBadRequest, body)
 		return
 	}
+	if explain {
+		respBodyBytes = addExplanation(respBodyBytes, newExplanation(router, resp, decision))
+	}
 
 	// Responding best response
+	metrics.ObserveSelected(resp.Name)
+	span.SetAttributes(attribute.String("apigator.selected_target", resp.Name))
 	logger.Info("Responding back to requester",
 		zap.String("apigator_target", resp.Name),
+		zap.String("request_id", id),
 	)
 	c.Writer.Write(respBodyBytes)
 }
 
+// targetExplanation describes how the request forwarded to a target and its
+// response were considered
+type targetExplanation struct {
+	Target        string   `json:"target"`
+	Status        string   `json:"status"`
+	StatusCode    int      `json:"status_code,omitempty"`
+	LatencyMS     int64    `json:"latency_ms,omitempty"`
+	Score         *float64 `json:"score,omitempty"`
+	DiscardReason string   `json:"discard_reason,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// explanation describes the decision taken by the router for a request
+type explanation struct {
+	ScoreFunction  string              `json:"score_function"`
+	SelectionMode  string              `json:"selection_mode"`
+	SelectedTarget string              `json:"selected_target,omitempty"`
+	Targets        []targetExplanation `json:"targets"`
+}
+
+// newExplanation returns the explanation of the decision. 'resp' is the
+// selected response, or nil if there is none
+func newExplanation(router *ag.APIGatorRouter, resp *ag.APIGatorResponse, decision []ag.TargetOutcome) explanation {
+	e := explanation{
+		ScoreFunction: router.ScoreFuncName,
+		SelectionMode: router.SelectionMode,
+		Targets:       make([]targetExplanation, len(decision)),
+	}
+	if resp != nil {
+		e.SelectedTarget = resp.Name
+	}
+	for i, outcome := range decision {
+		e.Targets[i] = targetExplanation{
+			Target:        outcome.Target,
+			Status:        outcome.Status,
+			StatusCode:    outcome.StatusCode,
+			LatencyMS:     outcome.Latency.Milliseconds(),
+			DiscardReason: outcome.DiscardReason,
+			Error:         outcome.Error,
+		}
+		if outcome.Evaluated {
+			score := outcome.Score
+			e.Targets[i].Score = &score
+		}
+	}
+	return e
+}
+
+// addExplanation adds the explanation to the JSON object of the response
+// body. The body is returned untouched if it's not a JSON object
+func addExplanation(body []byte, e explanation) []byte {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		logger.Error("Failed to add the explanation to the response", zap.Error(err))
+		return body
+	}
+	doc[explanationKey] = e
+	explained, err := json.Marshal(doc)
+	if err != nil {
+		logger.Error("Failed to add the explanation to the response", zap.Error(err))
+		return body
+	}
+	return explained
+}
+
+// setDecisionHeaders adds to the response the selected target with its score,
+// and the comma separated list of targets with a valid response
+func setDecisionHeaders(c *gin.Context, resp *ag.APIGatorResponse, decision []ag.TargetOutcome) {
+	var candidates []string
+	for _, outcome := range decision {
+		if outcome.Evaluated && outcome.Score > 0 {
+			candidates = append(candidates, outcome.Target)
+		}
+		if resp != nil && outcome.Target == resp.Name && outcome.Evaluated {
+			c.Header(scoreHeader, strconv.FormatFloat(outcome.Score, 'f', 4, 64))
+		}
+	}
+	if resp != nil {
+		c.Header(selectedTargetHeader, resp.Name)
+	}
+	c.Header(candidatesHeader, strings.Join(candidates, ","))
+}
+
+// cancelledByRouter reports if the requests to the targets were aborted
+// because a response was already selected or the requester disconnected. A
+// context done by the router deadline is not a cancellation, as the target
+// didn't answer in time
+func cancelledByRouter(ctx context.Context) bool {
+	cause := context.Cause(ctx)
+	return errors.Is(cause, errResponseSelected) || errors.Is(cause, context.Canceled)
+}
+
+// selectTargets returns the targets serving the jurisdiction and manifest of
+// the request, recording the rest as skipped. No target is returned if the
+// request must be rejected
+func selectTargets(router *ag.APIGatorRouter, jsonData map[string]interface{}, id string, outcomes *ag.Outcomes) []*ag.APIGatorTarget {
+	var req ag.RoutingRequest
+	req.CountryCode, _ = jsonData["countryCode"].(string)
+	req.DataOwningCountryCode, _ = jsonData["dataOwningCountryCode"].(string)
+	req.ManifestName, _ = jsonData["manifestName"].(string)
+
+	targets, fallback := router.SelectTargets(req)
+	if fallback {
+		logger.Warn("No APIGator target serves the jurisdiction and manifest of the request",
+			zap.String("request_id", id),
+			zap.String("country_code", req.CountryCode),
+			zap.String("data_owning_country_code", req.DataOwningCountryCode),
+			zap.String("manifest_name", req.ManifestName),
+			zap.String("routing_fallback", router.RoutingFallback),
+		)
+	}
+
+	selected := make(map[*ag.APIGatorTarget]bool, len(targets))
+	for _, target := range targets {
+		selected[target] = true
+	}
+	for _, target := range router.APIGatorTargets {
+		if !selected[target] {
+			logger.Debug("Skipping APIGator instance not serving the request", zap.String("apigator_target", target.Name), zap.String("request_id", id))
+			metrics.ObserveSkipped(target.Name, metricsSkipJurisdiction)
+			outcomes.Skipped(target.Name, "jurisdiction or manifest not served")
+		}
+	}
+	return targets
+}
+
+// enforceResidency returns the targets permitted by the data-residency
+// policy for the dataOwningCountryCode of the request. Every blocked target
+// is logged with the rule it breaks and recorded as skipped
+func enforceResidency(router *ag.APIGatorRouter, targets []*ag.APIGatorTarget, jsonData map[string]interface{}, id string, outcomes *ag.Outcomes) []*ag.APIGatorTarget {
+	dataOwningCountryCode, _ := jsonData["dataOwningCountryCode"].(string)
+
+	var permitted []*ag.APIGatorTarget
+	for _, target := range targets {
+		rule := router.Residency.Violation(target, dataOwningCountryCode)
+		if rule == nil {
+			permitted = append(permitted, target)
+			continue
+		}
+		logger.Warn("APIGator instance blocked by the data-residency policy",
+			zap.String("apigator_target", target.Name),
+			zap.String("location", target.Location),
+			zap.String("data_owning_country_code", dataOwningCountryCode),
+			zap.String("rule", rule.String()),
+			zap.String("request_id", id),
+		)
+		metrics.ObserveSkipped(target.Name, metricsSkipResidency)
+		outcomes.Skipped(target.Name, fmt.Sprintf("blocked by residency rule %q", rule.String()))
+	}
+	return permitted
+}
+
+// rejectRequest answers a request which is not forwarded to any target with
+// the status code and error message, and records the decision on the audit
+func rejectRequest(c *gin.Context, router *ag.APIGatorRouter, id string, jsonData map[string]interface{}, jsonBytes []byte, outcomes *ag.Outcomes, status int, message string) {
+	record := newAuditRecord(c, router, id, jsonData, jsonBytes)
+	record.Targets = auditTargets(outcomes.List())
+	if !writeAuditRecord(c, id, record) {
+		return
+	}
+
+	logger.Warn("Rejecting request", zap.String("request_id", id), zap.Int("status_code", status), zap.String("error", message))
+	c.JSON(status, gin.H{"error": message})
+}
+
+// writeAuditRecord records a routing decision before answering the requester.
+// If the record can't be written, the requester gets an error instead of the
+// decision, so no decision is served without its record. It reports if the
+// record was written
+func writeAuditRecord(c *gin.Context, id string, record audit.Record) bool {
+	if err := auditLog.Write(record); err != nil {
+		logger.Error("Failed to write audit record. Not serving the decision", zap.String("request_id", id), zap.Error(err))
+		metrics.ObserveAuditFailure()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record the routing decision"})
+		return false
+	}
+	return true
+}
+
+// requestID returns the ID sent by the requester on the X-Request-ID header,
+// or a new random one
+func requestID(c *gin.Context) string {
+	if id := c.GetHeader(requestIDHeader); id != "" {
+		return id
+	}
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// newAuditRecord returns the audit record of a request, without the outcome
+// of the targets. Only the hash of the request body is recorded
+func newAuditRecord(c *gin.Context, router *ag.APIGatorRouter, id string, jsonData map[string]interface{}, body []byte) audit.Record {
+	record := audit.Record{
+		RequestID:     id,
+		CallerIP:      c.RemoteIP(),
+		ScoreFunction: router.ScoreFuncName,
+		SelectionMode: router.SelectionMode,
+		RequestSHA256: audit.Digest(body),
+	}
+	if router.Audit.CallerHeader != "" {
+		record.Caller = c.GetHeader(router.Audit.CallerHeader)
+	}
+	record.CountryCode, _ = jsonData["countryCode"].(string)
+	record.DataOwningCountryCode, _ = jsonData["dataOwningCountryCode"].(string)
+	record.ManifestName, _ = jsonData["manifestName"].(string)
+	if dataUsageID, ok := jsonData["dataUsageId"]; ok && dataUsageID != nil {
+		record.DataUsageID = fmt.Sprint(dataUsageID)
+	}
+	return record
+}
+
+// auditTargets converts the outcome of every target to its audit record
+func auditTargets(outcomes []ag.TargetOutcome) []audit.TargetRecord {
+	targets := make([]audit.TargetRecord, len(outcomes))
+	for i, outcome := range outcomes {
+		targets[i] = audit.TargetRecord{
+			Target:         outcome.Target,
+			Status:         outcome.Status,
+			StatusCode:     outcome.StatusCode,
+			LatencyMS:      outcome.Latency.Milliseconds(),
+			DiscardReason:  outcome.DiscardReason,
+			ResponseSHA256: outcome.ResponseSHA256,
+		}
+		if outcome.Evaluated {
+			score := outcome.Score
+			targets[i].Score = &score
+		}
+	}
+	return targets
+}
+
 // processResponses reads every response obtained from the list of
 // APIGatorsTargets, and selects which is the best one based on the evaluation
-// function defined on the router's configuration
-func processResponses(responseChan <-chan ag.APIGatorResponse, restrictedText string, original string) *ag.APIGatorResponse {
-	// TODO: test with pointer
-	var bestResponse ag.APIGatorResponse
+// function defined on the router's configuration.
+// On race mode, it returns as soon as a response reaches the configured
+// 'race_score' without waiting for the rest of targets. On merge mode, every
+// valid response is merged into a single one. If the context is done
+// before every target answered, only the responses already received are
+// evaluated. If no response gets a positive score, nil is returned
+func processResponses(ctx context.Context, router *ag.APIGatorRouter, responseChan <-chan ag.APIGatorResponse, evalCtx ag.EvaluationContext, outcomes *ag.Outcomes, original string) *ag.APIGatorResponse {
+	var bestResponse *ag.APIGatorResponse
 	var bestScore float64 = 0.0
-	var name string
 
-	// Reading every response and evaulatin its score
-	for r := range responseChan {
+	// Every valid response and its score, for the merge mode
+	var candidates []*ag.APIGatorResponse
+	scores := make(map[*ag.APIGatorResponse]float64)
+
+	// evaluate scores a response and reports if the selection is already finished
+	evaluate := func(r ag.APIGatorResponse) bool {
 		defer r.Response.Body.Close()
-		score := r.EvaluateResponse(router.ScoreFunc, restrictedText, original, logger)
-		logger.Debug("Evaluating Response", zap.Float64("score", score))
+		_, span := tracing.Start(ctx, "EvaluateResponse", trace.WithAttributes(
+			attribute.String("apigator.target", r.Name),
+			attribute.String("score_function", router.ScoreFuncName),
+		))
+		score := r.EvaluateResponse(router.ScoreFunc, evalCtx, original, logger)
+		span.SetAttributes(attribute.Float64("score", score))
+		span.End()
+		outcomes.Responded(&r)
+		outcomes.Scored(r.Name, score, r.DiscardReason)
+		logger.Debug("Evaluating Response", zap.String("apigator", r.Name), zap.Float64("score", score))
+		metrics.ObserveScore(r.Name, router.ScoreFuncName, score)
+		if score > 0 {
+			candidates = append(candidates, &r)
+			scores[&r] = score
+		}
 		if score > bestScore {
 			logger.Debug("New Best Response", zap.Float64("score", score))
 			bestScore = score
-			bestResponse = r
-			name = r.Name
+			bestResponse = &r
+		}
+
+		// First acceptable response wins on race mode
+		if router.SelectionMode == ag.SelectionModeRace && score >= router.RaceScore {
+			logger.Debug("Response reached the race score. Discarding remaining targets",
+				zap.String("apigator", r.Name),
+				zap.Float64("race_score", router.RaceScore),
+			)
+			return true
+		}
+		return false
+	}
+
+	// Reading every response and evaulatin its score
+	finished := false
+	for !finished {
+		select {
+		case r, ok := <-responseChan:
+			finished = !ok || evaluate(r)
+		case <-ctx.Done():
+			logger.Warn("Stopped waiting for APIGator responses. Evaluating the responses already received", zap.Error(ctx.Err()))
+			for !finished {
+				select {
+				case r, ok := <-responseChan:
+					finished = !ok || evaluate(r)
+				default:
+					finished = true
+				}
+			}
+		}
+	}
+
+	if bestResponse == nil {
+		logger.Warn("No valid response received from any APIGator", zap.String("score_method", router.ScoreFuncName))
+		outcomes.Decided()
+		return nil
+	}
+
+	// On merge mode, the valid responses are merged from the best to the worst scored
+	if router.SelectionMode == ag.SelectionModeMerge {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return scores[candidates[i]] > scores[candidates[j]]
+		})
+		merged, err := ag.MergeResponses(candidates, &evalCtx, router.MergeProvenance)
+		if err != nil {
+			logger.Error("Failed to merge responses. Using best response", zap.Error(err))
+		} else {
+			logger.Debug("Merged Responses from APIGator",
+				zap.String("score_method", router.ScoreFuncName),
+				zap.Int("responses", len(candidates)),
+				zap.String("primary_apigator", merged.Name),
+			)
+			mergedTargets := make([]string, len(candidates))
+			for i, candidate := range candidates {
+				mergedTargets[i] = candidate.Name
+			}
+			outcomes.Decided(mergedTargets...)
+			return merged
 		}
 	}
 
 	logger.Debug("Selected Response from APIGator",
 		zap.String("score_method", router.ScoreFuncName),
-		zap.String("apigator", name),
+		zap.String("selection_mode", router.SelectionMode),
+		zap.String("apigator", bestResponse.Name),
 		zap.Float64("score", bestScore),
 	)
-	return &bestResponse
+	outcomes.Decided(bestResponse.Name)
+	return bestResponse
+}
+
+// applyConfig replaces the running configuration with a reloaded one. The
+// requests in-flight finish with the previous configuration. Tokens of the
+// targets whose credentials didn't change are kept, as well as the circuit
+// breakers and the recent latencies of the unchanged targets. Changes on the
+// listen address, path, tracing or audit are rejected, because they need a
+// restart
+func applyConfig(newRouter *ag.APIGatorRouter) error {
+	router := activeRouter.Load()
+	if newRouter.Host != router.Host || newRouter.Port != router.Port || newRouter.Path != router.Path {
+		return fmt.Errorf("host, port and path changes require a restart")
+	}
+	if newRouter.Tracing != router.Tracing || newRouter.Audit != router.Audit {
+		return fmt.Errorf("tracing and audit changes require a restart")
+	}
+
+	unusedTokens := newRouter.InheritTokens(router)
+	newRouter.InheritBreakers(router)
+	newRouter.InheritLatencies(router)
+	newRouter.StartHealthChecks(router)
+	activeRouter.Store(newRouter)
+	newRouter.PublishBreakers()
+	router.StopHealthChecks()
+	for _, tokens := range unusedTokens {
+		tokens.Close()
+	}
+
+	logger.Info("Applied new configuration",
+		zap.Int("apigators_count", len(newRouter.APIGatorTargets)),
+		zap.String("score_function", newRouter.ScoreFuncName),
+	)
+	return nil
+}
+
+// validateConfig runs the validate-config subcommand. It prints every problem
+// found on the configuration file and returns the exit code
+func validateConfig(args []string) int {
+	flags := flag.NewFlagSet(validateConfigCommand, flag.ExitOnError)
+	configFilePath := flags.String("config", "config.ini", "Path to the configuration file (INI, YAML or JSON)")
+	resolveSecrets := flags.Bool("resolve-secrets", false, "Resolve the file, env and exec secret references")
+	var overrides cfg.Overrides
+	flags.Var(&overrides, "set", "Override a setting of the configuration file as section.key=value (repeatable)")
+	_ = flags.Parse(args)
+
+	errs := cfg.ValidateConfig(*configFilePath, overrides, *resolveSecrets)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *configFilePath, err)
+		}
+		return 1
+	}
+
+	fmt.Printf("%s: configuration is valid\n", *configFilePath)
+	return 0
+}
+
+// verifyAudit runs the verify-audit subcommand. It checks the hash chain of
+// the rotated audit files, from the oldest to the newest, and then of the
+// current audit file, and returns the exit code
+func verifyAudit(args []string) int {
+	flags := flag.NewFlagSet(verifyAuditCommand, flag.ExitOnError)
+	auditFilePath := flags.String("file", "audit.log", "Path to the audit file")
+	_ = flags.Parse(args)
+
+	files, err := audit.RotatedFiles(*auditFilePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *auditFilePath, err)
+		return 1
+	}
+	files = append(files, *auditFilePath)
+
+	var lastHash string
+	for _, fileName := range files {
+		file, err := os.Open(fileName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", fileName, err)
+			return 1
+		}
+		lastHash, err = audit.Verify(file, lastHash)
+		file.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", fileName, err)
+			return 1
+		}
+	}
+
+	fmt.Printf("%s: audit chain is valid\n", *auditFilePath)
+	return 0
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == validateConfigCommand {
+		os.Exit(validateConfig(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == verifyAuditCommand {
+		os.Exit(verifyAudit(os.Args[2:]))
+	}
+
 	// Ignore Logger sync error
 	defer func() { _ = logger.Sync() }()
 
@@ -174,19 +842,50 @@ func main() {
 	logger.Debug("Debug Mode active!")
 
 	// Define the command-line flags
-	configFilePath := flag.String("config", "config.ini", "Path to the INI configuration file")
+	configFilePath := flag.String("config", "config.ini", "Path to the configuration file (INI, YAML or JSON)")
+	var overrides cfg.Overrides
+	flag.Var(&overrides, "set", "Override a setting of the configuration file as section.key=value (repeatable)")
+	strict := flag.Bool("strict", false, "Reject the configurations failing the validate-config checks, on startup and on every reload")
 	flag.Parse()
 
 	// Load the configuration
-	var err error
-	router, err = cfg.LoadConfig(*configFilePath, logger)
+	router, err := cfg.LoadConfig(*configFilePath, overrides, *strict, logger)
 	logger.Debug("Loading INI config file", zap.String("config_file", *configFilePath))
 	if err != nil {
 		logger.Fatal("Can't read INI config file", zap.Error(err))
 	}
+	activeRouter.Store(router)
+	router.PublishBreakers()
+	router.StartHealthChecks(nil)
+
+	// Exporting the tracing spans. Pending spans are flushed on exit
+	shutdownTracing, err := tracing.Setup(context.Background(), router.Tracing)
+	if err != nil {
+		logger.Fatal("Can't configure tracing", zap.Error(err))
+	}
+	defer func() { _ = shutdownTracing(context.Background()) }()
+
+	// Recording the routing decisions
+	if router.Audit.File != "" {
+		if auditLog, err = audit.Open(router.Audit); err != nil {
+			logger.Fatal("Can't open audit file", zap.Error(err))
+		}
+		defer auditLog.Close()
+		logger.Info("Auditing routing decisions", zap.String("audit_file", router.Audit.File))
+	}
+
+	// Reloading the configuration when the file changes or on SIGHUP
+	watcher := cfg.NewWatcher(*configFilePath, overrides, *strict, logger, applyConfig)
+	go watcher.Run(context.Background(), router.ReloadInterval*time.Second)
 
 	gRouter.POST(router.Path, forwardRequest)
 	gRouter.GET(healthcheckPath, healthcheckHandler)
+	gRouter.GET(livenessPath, healthcheckHandler)
+	gRouter.GET(readinessPath, readinessHandler)
+	gRouter.GET(targetsHealthPath, targetsHealthHandler)
+	gRouter.GET(tokenStatusPath, tokenStatusHandler)
+	gRouter.GET(breakerStatusPath, breakerStatusHandler)
+	gRouter.GET(metricsPath, gin.WrapH(metrics.Handler()))
 	listenAddress := router.Host + ":" + fmt.Sprintf("%d", router.Port)
 
 	logger.Info("Listening for requests")
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"exate-dora-router/internal/audit"
+	cfg "exate-dora-router/internal/config"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// testRestrictedText is the restrictedText of the test requests
+	testRestrictedText = "*****"
+	// testDataSet is the dataSet of the test requests
+	testDataSet = `{"name":"Alice","email":"alice@example.com","city":"Leeds","phone":"555"}`
+)
+
+// testTarget is a fake APIGator target answering with 'dataSet'. The
+// 'config' keys are added to its section
+type testTarget struct {
+	name    string
+	dataSet string
+	config  string
+}
+
+// newTestAPIGator starts a fake APIGator returning the 'dataSet' for every
+// request, and returns its URL
+func newTestAPIGator(t *testing.T, dataSet string) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/token") {
+			fmt.Fprint(w, `{"access_token":"token","expires_in":3600}`)
+			return
+		}
+		body, _ := json.Marshal(map[string]string{"dataSet": dataSet})
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// setupRouter loads a configuration with the 'targets' and the 'routerConfig'
+// keys of the [router] section, and makes it the running one
+func setupRouter(t *testing.T, routerConfig string, targets ...testTarget) {
+	t.Helper()
+
+	config := "[router]\npath = /forward\nscore_function = percentage\n" + routerConfig + "\n[common]\ntimeout = 5\nhealth_check_interval = -1\n"
+	for _, target := range targets {
+		config += fmt.Sprintf("\n[api_gator_%s]\nname = %s\nhost = %s\nclient_id = client\nclient_secret = secret\napi_key = key\n%s\n",
+			strings.ToLower(target.name), target.name, newTestAPIGator(t, target.dataSet), target.config)
+	}
+	fileName := filepath.Join(t.TempDir(), "config.ini")
+	if err := os.WriteFile(fileName, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	router, err := cfg.LoadConfig(fileName, nil, true, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := activeRouter.Swap(router)
+	t.Cleanup(func() { activeRouter.Store(previous) })
+}
+
+// setupAudit makes the router record the decisions on a new audit file, and
+// returns its path
+func setupAudit(t *testing.T) string {
+	t.Helper()
+
+	fileName := filepath.Join(t.TempDir(), "audit.log")
+	var err error
+	if auditLog, err = audit.Open(audit.Config{File: fileName, MaxSize: 1}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		auditLog.Close()
+		auditLog = nil
+	})
+	return fileName
+}
+
+// forward sends a request with the 'request' fields and the 'headers' to the
+// running router
+func forward(t *testing.T, request map[string]interface{}, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body := map[string]interface{}{"restrictedText": testRestrictedText, "dataSet": testDataSet}
+	for key, value := range request {
+		body[key] = value
+	}
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/forward", bytes.NewReader(bodyBytes))
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = req
+	forwardRequest(c)
+	return rec
+}
+
+// readAudit returns the records of an audit file
+func readAudit(t *testing.T, fileName string) []audit.Record {
+	t.Helper()
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var records []audit.Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record audit.Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestForwardRequestAudit(t *testing.T) {
+	setupRouter(t, "", testTarget{name: "A", dataSet: `{"name":"Alice","email":"*****","city":"Leeds","phone":"555"}`})
+	fileName := setupAudit(t)
+
+	rec := forward(t, map[string]interface{}{"countryCode": "GB", "manifestName": "Employee"}, map[string]string{requestIDHeader: "request-1"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	// The record is already durable when the requester gets the response
+	records := readAudit(t, fileName)
+	if len(records) != 1 {
+		t.Fatalf("%d audit records, want 1", len(records))
+	}
+	record := records[0]
+	if record.RequestID != "request-1" || record.SelectedTarget != "A" || record.CountryCode != "GB" || record.ManifestName != "Employee" {
+		t.Errorf("audit record %+v", record)
+	}
+	if record.ResponseSHA256 != audit.Digest(rec.Body.Bytes()) {
+		t.Errorf("audit record response hash %s, want the hash of the response", record.ResponseSHA256)
+	}
+	if len(record.Targets) != 1 || record.Targets[0].Status != "responded" {
+		t.Errorf("audit record targets %+v", record.Targets)
+	}
+}
+
+func TestForwardRequestAuditFailure(t *testing.T) {
+	setupRouter(t, "", testTarget{name: "A", dataSet: `{"name":"Alice","email":"*****","city":"Leeds","phone":"555"}`})
+	setupAudit(t)
+	// Writing on a closed audit file fails
+	auditLog.Close()
+
+	rec := forward(t, nil, nil)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status code %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if strings.Contains(rec.Body.String(), "dataSet") {
+		t.Errorf("decision served without its audit record: %s", rec.Body)
+	}
+}
+
+func TestForwardRequestDecisionHeaders(t *testing.T) {
+	targets := []testTarget{
+		{name: "A", dataSet: `{"name":"Alice","email":"*****","city":"Leeds","phone":"555"}`},
+		{name: "B", dataSet: `{"name":"Alice","email":"*****","city":"*****","phone":"555"}`},
+		{name: "C", dataSet: `{"name":"*****","email":"*****","city":"*****","phone":"*****"}`},
+	}
+
+	tests := []struct {
+		name         string
+		routerConfig string
+		targets      []testTarget
+		want         map[string]string
+	}{
+		{
+			name:    "disabled",
+			targets: targets,
+			want:    map[string]string{selectedTargetHeader: "", scoreHeader: "", candidatesHeader: ""},
+		},
+		{
+			name:         "selected response",
+			routerConfig: "decision_headers = true",
+			targets:      targets,
+			want:         map[string]string{selectedTargetHeader: "A", scoreHeader: "0.7500", candidatesHeader: "A,B"},
+		},
+		{
+			name:         "no valid response",
+			routerConfig: "decision_headers = true",
+			targets:      targets[2:],
+			want:         map[string]string{selectedTargetHeader: "", scoreHeader: "", candidatesHeader: ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupRouter(t, tt.routerConfig, tt.targets...)
+			rec := forward(t, nil, nil)
+			for header, want := range tt.want {
+				if got := rec.Header().Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestForwardRequestExplain(t *testing.T) {
+	targets := []testTarget{
+		{name: "A", dataSet: `{"name":"Alice","email":"*****","city":"Leeds","phone":"555"}`},
+		{name: "B", dataSet: `{"name":"Alice","email":"*****","city":"*****","phone":"555"}`},
+		{name: "C", dataSet: `{"name":"*****","email":"*****","city":"*****","phone":"*****"}`},
+	}
+	score := func(score float64) *float64 { return &score }
+
+	tests := []struct {
+		name         string
+		routerConfig string
+		targets      []testTarget
+		header       string
+		wantCode     int
+		want         *explanation
+	}{
+		{
+			name:         "not requested",
+			routerConfig: "allow_explain = true",
+			targets:      targets,
+			wantCode:     http.StatusOK,
+		},
+		{
+			name:     "not allowed",
+			targets:  targets,
+			header:   "true",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:         "selected response",
+			routerConfig: "allow_explain = true",
+			targets:      targets,
+			header:       "TRUE",
+			wantCode:     http.StatusOK,
+			want: &explanation{
+				ScoreFunction:  "percentage",
+				SelectionMode:  "best",
+				SelectedTarget: "A",
+				Targets: []targetExplanation{
+					{Target: "A", Status: "responded", StatusCode: 200, Score: score(0.75)},
+					{Target: "B", Status: "responded", StatusCode: 200, Score: score(0.5), DiscardReason: "lower_score"},
+					{Target: "C", Status: "responded", StatusCode: 200, Score: score(0), DiscardReason: "no_score"},
+				},
+			},
+		},
+		{
+			name:         "no valid response",
+			routerConfig: "allow_explain = true",
+			targets:      targets[2:],
+			header:       "true",
+			wantCode:     http.StatusBadRequest,
+			want: &explanation{
+				ScoreFunction: "percentage",
+				SelectionMode: "best",
+				Targets: []targetExplanation{
+					{Target: "C", Status: "responded", StatusCode: 200, Score: score(0), DiscardReason: "no_score"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupRouter(t, tt.routerConfig, tt.targets...)
+			rec := forward(t, nil, map[string]string{explainHeader: tt.header})
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status code %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+
+			var body struct {
+				Explanation *explanation `json:"explanation"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			// Latencies vary on every run
+			if body.Explanation != nil {
+				for i := range body.Explanation.Targets {
+					body.Explanation.Targets[i].LatencyMS = 0
+				}
+			}
+			if !reflect.DeepEqual(body.Explanation, tt.want) {
+				got, _ := json.Marshal(body.Explanation)
+				want, _ := json.Marshal(tt.want)
+				t.Errorf("explanation = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+// auditStatuses returns the status of every target on an audit record
+func auditStatuses(record audit.Record) map[string]string {
+	statuses := make(map[string]string, len(record.Targets))
+	for _, target := range record.Targets {
+		statuses[target.Target] = target.Status
+	}
+	return statuses
+}
+
+func TestForwardRequestRouting(t *testing.T) {
+	const dataSet = `{"name":"Alice","email":"*****","city":"Leeds","phone":"555"}`
+	uk := testTarget{name: "UK", dataSet: dataSet, config: "countries = GB"}
+	germany := testTarget{name: "GERMANY", dataSet: dataSet, config: "countries = DE\nmanifests = Employee"}
+	global := testTarget{name: "GLOBAL", dataSet: dataSet}
+
+	tests := []struct {
+		name         string
+		routerConfig string
+		targets      []testTarget
+		request      map[string]interface{}
+		wantCode     int
+		want         map[string]string
+	}{
+		{
+			name:     "matching target",
+			targets:  []testTarget{uk, germany},
+			request:  map[string]interface{}{"countryCode": "GB"},
+			wantCode: http.StatusOK,
+			want:     map[string]string{"UK": "responded", "GERMANY": "skipped"},
+		},
+		{
+			name:     "default target",
+			targets:  []testTarget{uk, germany, global},
+			request:  map[string]interface{}{"countryCode": "DE", "manifestName": "Customer"},
+			wantCode: http.StatusOK,
+			want:     map[string]string{"UK": "skipped", "GERMANY": "skipped", "GLOBAL": "responded"},
+		},
+		{
+			name:     "broadcast fallback",
+			targets:  []testTarget{uk, germany},
+			request:  map[string]interface{}{"countryCode": "FR"},
+			wantCode: http.StatusOK,
+			want:     map[string]string{"UK": "responded", "GERMANY": "responded"},
+		},
+		{
+			name:         "reject fallback",
+			routerConfig: "routing_fallback = reject",
+			targets:      []testTarget{uk, germany},
+			request:      map[string]interface{}{"countryCode": "FR"},
+			wantCode:     http.StatusUnprocessableEntity,
+			want:         map[string]string{"UK": "skipped", "GERMANY": "skipped"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupRouter(t, tt.routerConfig, tt.targets...)
+			fileName := setupAudit(t)
+
+			rec := forward(t, tt.request, nil)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status code %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			records := readAudit(t, fileName)
+			if len(records) != 1 {
+				t.Fatalf("%d audit records, want 1", len(records))
+			}
+			if got := auditStatuses(records[0]); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("target statuses %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardRequestResidency(t *testing.T) {
+	const (
+		dataSet = `{"name":"Alice","email":"*****","city":"Leeds","phone":"555"}`
+		// Sections following the [router] keys
+		residency = "[regions]\nEU = DE,FR,IE\n[residency]\nGB = GB\nEU = EU"
+	)
+	uk := testTarget{name: "UK", dataSet: dataSet, config: "countries = GB\nlocation = GB"}
+	germany := testTarget{name: "GERMANY", dataSet: dataSet, config: "countries = DE,FR\nlocation = DE"}
+	global := testTarget{name: "GLOBAL", dataSet: dataSet, config: "location = US"}
+
+	tests := []struct {
+		name         string
+		routerConfig string
+		targets      []testTarget
+		request      map[string]interface{}
+		wantCode     int
+		want         map[string]string
+	}{
+		{
+			name:         "allowed and denied locations",
+			routerConfig: residency,
+			targets:      []testTarget{uk, germany, global},
+			request:      map[string]interface{}{"countryCode": "FR", "dataOwningCountryCode": "FR"},
+			wantCode:     http.StatusOK,
+			want:         map[string]string{"UK": "skipped", "GERMANY": "responded", "GLOBAL": "skipped"},
+		},
+		{
+			name:         "owner without rules",
+			routerConfig: residency,
+			targets:      []testTarget{uk, germany, global},
+			request:      map[string]interface{}{"countryCode": "US", "dataOwningCountryCode": "US"},
+			wantCode:     http.StatusOK,
+			want:         map[string]string{"UK": "skipped", "GERMANY": "skipped", "GLOBAL": "responded"},
+		},
+		{
+			name:         "every target denied",
+			routerConfig: residency,
+			targets:      []testTarget{germany, global},
+			request:      map[string]interface{}{"countryCode": "GB", "dataOwningCountryCode": "GB"},
+			wantCode:     http.StatusForbidden,
+			want:         map[string]string{"GERMANY": "skipped", "GLOBAL": "skipped"},
+		},
+		{
+			name:         "missing owner with enforcement",
+			routerConfig: residency,
+			targets:      []testTarget{uk, germany, global},
+			request:      map[string]interface{}{"countryCode": "GB"},
+			wantCode:     http.StatusBadRequest,
+			want:         map[string]string{"UK": "skipped", "GERMANY": "skipped", "GLOBAL": "skipped"},
+		},
+		{
+			name:         "owner not a string with enforcement",
+			routerConfig: residency,
+			targets:      []testTarget{uk, germany, global},
+			request:      map[string]interface{}{"countryCode": "GB", "dataOwningCountryCode": 826},
+			wantCode:     http.StatusBadRequest,
+			want:         map[string]string{"UK": "skipped", "GERMANY": "skipped", "GLOBAL": "skipped"},
+		},
+		{
+			name:     "missing owner without enforcement",
+			targets:  []testTarget{uk, germany, global},
+			request:  map[string]interface{}{"countryCode": "GB"},
+			wantCode: http.StatusOK,
+			want:     map[string]string{"UK": "responded", "GERMANY": "skipped", "GLOBAL": "responded"},
+		},
+		{
+			name:         "routing applied first",
+			routerConfig: residency,
+			targets:      []testTarget{uk, germany, global},
+			request:      map[string]interface{}{"countryCode": "GB", "dataOwningCountryCode": "GB"},
+			wantCode:     http.StatusOK,
+			want:         map[string]string{"UK": "responded", "GERMANY": "skipped", "GLOBAL": "skipped"},
+		},
+		{
+			name:         "broadcast fallback restricted",
+			routerConfig: residency,
+			targets:      []testTarget{uk, germany},
+			request:      map[string]interface{}{"countryCode": "IE", "dataOwningCountryCode": "IE"},
+			wantCode:     http.StatusOK,
+			want:         map[string]string{"UK": "skipped", "GERMANY": "responded"},
+		},
+		{
+			name:         "reject fallback before residency",
+			routerConfig: "routing_fallback = reject\n" + residency,
+			targets:      []testTarget{uk, germany},
+			request:      map[string]interface{}{"countryCode": "IE", "dataOwningCountryCode": "IE"},
+			wantCode:     http.StatusUnprocessableEntity,
+			want:         map[string]string{"UK": "skipped", "GERMANY": "skipped"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupRouter(t, tt.routerConfig, tt.targets...)
+			fileName := setupAudit(t)
+
+			rec := forward(t, tt.request, nil)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status code %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			records := readAudit(t, fileName)
+			if len(records) != 1 {
+				t.Fatalf("%d audit records, want 1", len(records))
+			}
+			if got := auditStatuses(records[0]); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("target statuses %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
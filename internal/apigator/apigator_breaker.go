@@ -0,0 +1,233 @@
+package apigator
+
+import (
+	"exate-dora-router/internal/metrics"
+	"sync"
+	"time"
+)
+
+const (
+	// BreakerClosed lets every request through
+	BreakerClosed = "closed"
+	// BreakerOpen skips every request until the cool-down period finishes
+	BreakerOpen = "open"
+	// BreakerHalfOpen lets a single request through for probing the target
+	BreakerHalfOpen = "half-open"
+
+	// defaultBreakerWindow is the number of recent requests for computing the
+	// error rate when 'breaker_window' is not configured
+	defaultBreakerWindow = 20
+	// defaultBreakerCooldown is the time the breaker stays open when
+	// 'breaker_cooldown' is not configured
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// BreakerState describes the state of the CircuitBreaker of an APIGatorTarget
+type BreakerState struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	ErrorRate           float64    `json:"error_rate"`
+	Requests            int        `json:"requests"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+}
+
+// CircuitBreaker stops forwarding requests to an APIGatorTarget that keeps
+// failing. It opens after 'failures' consecutive failures or when the error
+// rate of the last 'window' requests reaches 'errorRate'. Once the cool-down
+// period finishes, a single request probes the target: if it succeeds the
+// breaker closes, otherwise it opens again. It can be used concurrently
+type CircuitBreaker struct {
+	target    string
+	failures  int
+	errorRate float64
+	cooldown  time.Duration
+
+	mu          sync.Mutex
+	state       string
+	consecutive int
+	// Results of the last requests (true means failure), as a ring buffer
+	results  []bool
+	next     int
+	count    int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker returns the CircuitBreaker for the target named 'target'.
+// A 0 'failures' or 'errorRate' disables that threshold. 0 'window' or
+// 'cooldown' means their default values. Its state is not published on the
+// metrics until the configuration is accepted and PublishBreakers is called
+func NewCircuitBreaker(target string, failures int, errorRate float64, window int, cooldown time.Duration) *CircuitBreaker {
+	if window == 0 {
+		window = defaultBreakerWindow
+	}
+	if cooldown == 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	return &CircuitBreaker{
+		target:    target,
+		failures:  failures,
+		errorRate: errorRate,
+		cooldown:  cooldown,
+		state:     BreakerClosed,
+		results:   make([]bool, window),
+	}
+}
+
+// enabled reports if any threshold is configured
+func (b *CircuitBreaker) enabled() bool {
+	return b.failures > 0 || b.errorRate > 0
+}
+
+// Allow reports if a request can be forwarded to the target. When the
+// cool-down period is finished, the first caller gets the probe request, and
+// it must report its result with Success, Failure or Cancelled
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// Success records a request answered by the target
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.consecutive = 0
+	if b.state == BreakerHalfOpen {
+		b.reset()
+		b.setState(BreakerClosed)
+		return
+	}
+	b.record(false)
+}
+
+// Failure records a request failed because of the target
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.consecutive++
+	if b.state == BreakerHalfOpen {
+		b.open()
+		return
+	}
+	b.record(true)
+
+	if !b.enabled() || b.state != BreakerClosed {
+		return
+	}
+	if (b.failures > 0 && b.consecutive >= b.failures) || (b.errorRate > 0 && b.count == len(b.results) && b.rate() >= b.errorRate) {
+		b.open()
+	}
+}
+
+// Cancelled records a request aborted by the router before the target
+// answered, so it doesn't count as a success nor as a failure
+func (b *CircuitBreaker) Cancelled() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+	}
+}
+
+// State returns the state of the CircuitBreaker
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := BreakerState{
+		State:               b.state,
+		ConsecutiveFailures: b.consecutive,
+		ErrorRate:           b.rate(),
+		Requests:            b.count,
+	}
+	if b.state != BreakerClosed {
+		openedAt, retryAt := b.openedAt, b.openedAt.Add(b.cooldown)
+		state.OpenedAt = &openedAt
+		state.RetryAt = &retryAt
+	}
+	return state
+}
+
+// record adds the result of a request to the window. It must be called
+// holding the lock
+func (b *CircuitBreaker) record(failure bool) {
+	b.results[b.next] = failure
+	b.next = (b.next + 1) % len(b.results)
+	if b.count < len(b.results) {
+		b.count++
+	}
+}
+
+// rate returns the error rate of the window. It must be called holding the lock
+func (b *CircuitBreaker) rate() float64 {
+	if b.count == 0 {
+		return 0
+	}
+	failed := 0
+	for i := 0; i < b.count; i++ {
+		if b.results[i] {
+			failed++
+		}
+	}
+	return float64(failed) / float64(b.count)
+}
+
+// open opens the breaker. It must be called holding the lock
+func (b *CircuitBreaker) open() {
+	b.openedAt = time.Now()
+	b.probing = false
+	b.setState(BreakerOpen)
+}
+
+// reset clears the results of the window. It must be called holding the lock
+func (b *CircuitBreaker) reset() {
+	b.consecutive = 0
+	b.next = 0
+	b.count = 0
+	b.probing = false
+}
+
+// publish publishes the state of the breaker on the metrics. It's used once
+// the configuration of the breaker is in use
+func (b *CircuitBreaker) publish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	metrics.SetBreakerState(b.target, b.state)
+}
+
+// sameSettings reports if two breakers have the same thresholds
+func (b *CircuitBreaker) sameSettings(other *CircuitBreaker) bool {
+	return b.failures == other.failures &&
+		b.errorRate == other.errorRate &&
+		b.cooldown == other.cooldown &&
+		len(b.results) == len(other.results)
+}
+
+// setState changes the state of the breaker, publishing it on the metrics.
+// It must be called holding the lock
+func (b *CircuitBreaker) setState(state string) {
+	b.state = state
+	metrics.SetBreakerState(b.target, state)
+}
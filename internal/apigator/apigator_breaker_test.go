@@ -0,0 +1,184 @@
+package apigator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	// Every step is an event on the breaker ("allow" and "deny" call Allow
+	// expecting true and false) followed by the expected state
+	type step struct {
+		event string
+		state string
+	}
+
+	tests := []struct {
+		name      string
+		failures  int
+		errorRate float64
+		window    int
+		cooldown  time.Duration
+		steps     []step
+	}{
+		{
+			name:     "opens on consecutive failures",
+			failures: 2,
+			cooldown: time.Hour,
+			steps: []step{
+				{"failure", BreakerClosed},
+				{"success", BreakerClosed},
+				{"failure", BreakerClosed},
+				{"failure", BreakerOpen},
+				{"deny", BreakerOpen},
+			},
+		},
+		{
+			name:      "opens on error rate once the window is full",
+			errorRate: 0.5,
+			window:    4,
+			cooldown:  time.Hour,
+			steps: []step{
+				{"success", BreakerClosed},
+				{"failure", BreakerClosed},
+				{"success", BreakerClosed},
+				{"failure", BreakerOpen},
+			},
+		},
+		{
+			name:      "stays closed below the error rate",
+			errorRate: 0.5,
+			window:    4,
+			cooldown:  time.Hour,
+			steps: []step{
+				{"failure", BreakerClosed},
+				{"success", BreakerClosed},
+				{"success", BreakerClosed},
+				{"success", BreakerClosed},
+				{"failure", BreakerClosed},
+			},
+		},
+		{
+			name:     "disabled without thresholds",
+			cooldown: time.Nanosecond,
+			steps: []step{
+				{"failure", BreakerClosed},
+				{"failure", BreakerClosed},
+				{"allow", BreakerClosed},
+			},
+		},
+		{
+			name:     "half-open lets a single probe through",
+			failures: 1,
+			cooldown: time.Nanosecond,
+			steps: []step{
+				{"failure", BreakerOpen},
+				{"allow", BreakerHalfOpen},
+				{"deny", BreakerHalfOpen},
+			},
+		},
+		{
+			name:     "successful probe closes the breaker",
+			failures: 1,
+			cooldown: time.Nanosecond,
+			steps: []step{
+				{"failure", BreakerOpen},
+				{"allow", BreakerHalfOpen},
+				{"success", BreakerClosed},
+				{"allow", BreakerClosed},
+				{"allow", BreakerClosed},
+			},
+		},
+		{
+			name:     "failed probe opens the breaker again",
+			failures: 3,
+			cooldown: time.Nanosecond,
+			steps: []step{
+				{"failure", BreakerClosed},
+				{"failure", BreakerClosed},
+				{"failure", BreakerOpen},
+				{"allow", BreakerHalfOpen},
+				{"failure", BreakerOpen},
+			},
+		},
+		{
+			name:     "cancelled probe releases the probe",
+			failures: 1,
+			cooldown: time.Nanosecond,
+			steps: []step{
+				{"failure", BreakerOpen},
+				{"allow", BreakerHalfOpen},
+				{"cancel", BreakerHalfOpen},
+				{"allow", BreakerHalfOpen},
+				{"deny", BreakerHalfOpen},
+			},
+		},
+		{
+			name:     "cancelled requests are not counted",
+			failures: 2,
+			cooldown: time.Hour,
+			steps: []step{
+				{"failure", BreakerClosed},
+				{"cancel", BreakerClosed},
+				{"failure", BreakerOpen},
+			},
+		},
+		{
+			name:     "open until the cool-down finishes",
+			failures: 1,
+			cooldown: time.Hour,
+			steps: []step{
+				{"failure", BreakerOpen},
+				{"deny", BreakerOpen},
+				{"deny", BreakerOpen},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker("test", tt.failures, tt.errorRate, tt.window, tt.cooldown)
+			for i, s := range tt.steps {
+				switch s.event {
+				case "allow", "deny":
+					if got := b.Allow(); got != (s.event == "allow") {
+						t.Fatalf("step %d: Allow = %v", i, got)
+					}
+				case "success":
+					b.Success()
+				case "failure":
+					b.Failure()
+				case "cancel":
+					b.Cancelled()
+				}
+				if got := b.State().State; got != s.state {
+					t.Fatalf("step %d (%s): state = %s, want %s", i, s.event, got, s.state)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerState(t *testing.T) {
+	b := NewCircuitBreaker("test", 0, 0.5, 4, time.Minute)
+	b.Failure()
+	b.Success()
+
+	state := b.State()
+	if state.State != BreakerClosed || state.ConsecutiveFailures != 0 || state.Requests != 2 || state.ErrorRate != 0.5 {
+		t.Errorf("State = %+v", state)
+	}
+	if state.OpenedAt != nil || state.RetryAt != nil {
+		t.Errorf("closed breaker reports OpenedAt %v and RetryAt %v", state.OpenedAt, state.RetryAt)
+	}
+
+	b.Failure()
+	b.Failure()
+	state = b.State()
+	if state.State != BreakerOpen || state.ConsecutiveFailures != 2 || state.OpenedAt == nil || state.RetryAt == nil {
+		t.Fatalf("State = %+v", state)
+	}
+	if state.RetryAt.Sub(*state.OpenedAt) != time.Minute {
+		t.Errorf("RetryAt = %v, want a minute after %v", *state.RetryAt, *state.OpenedAt)
+	}
+}
@@ -0,0 +1,132 @@
+package apigator
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	// compositeEvaluatorName is the name of the composite evaluator on the registry
+	compositeEvaluatorName = "composite"
+
+	// defaultLatencyBudget is the latency at which a response gets the worst latency score
+	defaultLatencyBudget = 10 * time.Second
+)
+
+// Registering the composite evaluator
+func init() {
+	registerEvaluator(compositeEvaluatorName, defaultCompositeEvaluatorParams, NewCompositeEvaluator)
+}
+
+// CompositeEvaluatorParams defines the parameters of the "composite"
+// evaluator, configured on the "[evaluator.composite]" section
+type CompositeEvaluatorParams struct {
+	// Names of the registered evaluators to combine
+	Evaluators []string `ini:"evaluators" delim:","`
+	// Weight of each evaluator, in the same order. Every evaluator weights 1.0
+	// if they're not defined
+	Weights []float64 `ini:"weights" delim:","`
+	// Weight of the latency term. 0 disables it
+	LatencyWeight float64 `ini:"latency_weight"`
+	// Latency in seconds from which a response gets the worst latency score
+	LatencyBudget time.Duration `ini:"latency_budget"`
+	// Weight of the target priority term. 0 disables it
+	PriorityWeight float64 `ini:"priority_weight"`
+}
+
+// defaultCompositeEvaluatorParams are the parameters used when the
+// "composite" evaluator has no configuration section
+var defaultCompositeEvaluatorParams = CompositeEvaluatorParams{
+	Evaluators:    []string{"basic"},
+	LatencyBudget: defaultLatencyBudget / time.Second,
+}
+
+// compositeComponent is one of the evaluators combined by the composite evaluator
+type compositeComponent struct {
+	name      string
+	weight    float64
+	evaluator APIGatorResponseEvaluator
+}
+
+// NewCompositeEvaluator returns an evaluator which combines the scores of
+// several evaluators, the latency of the response and the priority of the
+// APIGatorTarget as a weighted average. If any of the evaluators disqualifies
+// the response (negative score) the response is disqualified as well
+func NewCompositeEvaluator(params CompositeEvaluatorParams, load EvaluatorParamsLoader) (APIGatorResponseEvaluator, error) {
+	if len(params.Evaluators) == 0 {
+		return nil, fmt.Errorf("at least one evaluator is required")
+	}
+	if len(params.Weights) != 0 && len(params.Weights) != len(params.Evaluators) {
+		return nil, fmt.Errorf("%d weights defined for %d evaluators", len(params.Weights), len(params.Evaluators))
+	}
+	if params.LatencyWeight < 0 || params.PriorityWeight < 0 {
+		return nil, fmt.Errorf("latency_weight and priority_weight can't be negative")
+	}
+	if params.LatencyWeight > 0 && params.LatencyBudget <= 0 {
+		return nil, fmt.Errorf("latency_budget must be greater than 0")
+	}
+
+	// Building every evaluator with its own parameters
+	components := make([]compositeComponent, len(params.Evaluators))
+	totalWeight := params.LatencyWeight + params.PriorityWeight
+	for i, name := range params.Evaluators {
+		if name == compositeEvaluatorName {
+			return nil, fmt.Errorf("a composite evaluator can't include itself")
+		}
+		evaluator, err := NewEvaluator(name, load)
+		if err != nil {
+			return nil, err
+		}
+
+		weight := bestResponseScore
+		if len(params.Weights) != 0 {
+			weight = params.Weights[i]
+		}
+		if weight < 0 {
+			return nil, fmt.Errorf("weight of evaluator %q can't be negative", name)
+		}
+
+		components[i] = compositeComponent{name: name, weight: weight, evaluator: evaluator}
+		totalWeight += weight
+	}
+	if totalWeight == 0 {
+		return nil, fmt.Errorf("the sum of the weights must be greater than 0")
+	}
+
+	latencyBudget := params.LatencyBudget * time.Second
+
+	return func(data map[string]interface{}, logger *zap.Logger, ec *EvaluationContext) float64 {
+		var score float64
+		fields := []zap.Field{zap.String("apigator", ec.Target)}
+
+		for _, c := range components {
+			componentScore := c.evaluator(data, logger, ec)
+			fields = append(fields, zap.Float64(c.name, componentScore))
+			if componentScore < worstResponseScore {
+				logger.Debug("Response disqualified by composite evaluator", fields...)
+				return componentScore
+			}
+			score += c.weight * componentScore
+		}
+
+		// Faster responses get higher latency scores
+		if params.LatencyWeight > 0 {
+			latencyScore := bestResponseScore - float64(ec.Latency)/float64(latencyBudget)
+			if latencyScore < worstResponseScore {
+				latencyScore = worstResponseScore
+			}
+			fields = append(fields, zap.Float64("latency", latencyScore))
+			score += params.LatencyWeight * latencyScore
+		}
+
+		if params.PriorityWeight > 0 {
+			fields = append(fields, zap.Float64("priority", ec.Priority))
+			score += params.PriorityWeight * ec.Priority
+		}
+
+		score = score / totalWeight
+		logger.Debug("Composite evaluation", append(fields, zap.Float64("score", score))...)
+		return score
+	}, nil
+}
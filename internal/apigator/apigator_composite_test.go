@@ -0,0 +1,107 @@
+package apigator
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestNewCompositeEvaluatorErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		params CompositeEvaluatorParams
+	}{
+		{name: "no evaluators", params: CompositeEvaluatorParams{}},
+		{name: "weights mismatch", params: CompositeEvaluatorParams{Evaluators: []string{"basic", "test_constant"}, Weights: []float64{1}}},
+		{name: "negative weight", params: CompositeEvaluatorParams{Evaluators: []string{"basic"}, Weights: []float64{-1}}},
+		{name: "negative latency weight", params: CompositeEvaluatorParams{Evaluators: []string{"basic"}, LatencyWeight: -1, LatencyBudget: 10}},
+		{name: "negative priority weight", params: CompositeEvaluatorParams{Evaluators: []string{"basic"}, PriorityWeight: -1}},
+		{name: "latency without budget", params: CompositeEvaluatorParams{Evaluators: []string{"basic"}, LatencyWeight: 1}},
+		{name: "including itself", params: CompositeEvaluatorParams{Evaluators: []string{"basic", compositeEvaluatorName}}},
+		{name: "unknown evaluator", params: CompositeEvaluatorParams{Evaluators: []string{"unknown"}}},
+		{name: "zero weights", params: CompositeEvaluatorParams{Evaluators: []string{"basic"}, Weights: []float64{0}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewCompositeEvaluator(tt.params, iniParamsLoader(t, "")); err == nil {
+				t.Errorf("NewCompositeEvaluator(%+v) accepted invalid parameters", tt.params)
+			}
+		})
+	}
+}
+
+func TestCompositeEvaluator(t *testing.T) {
+	const config = "[evaluator.test_constant]\nscore = 0.8\n"
+
+	tests := []struct {
+		name   string
+		params CompositeEvaluatorParams
+		ec     EvaluationContext
+		want   float64
+	}{
+		{name: "single evaluator", params: CompositeEvaluatorParams{Evaluators: []string{"test_constant"}}, want: 0.8},
+		{name: "weights normalised", params: CompositeEvaluatorParams{Evaluators: []string{"test_constant"}, Weights: []float64{4}}, want: 0.8},
+		{name: "equal weights by default", params: CompositeEvaluatorParams{Evaluators: []string{"test_constant", "basic"}}, want: 0.4},
+		{name: "weighted average", params: CompositeEvaluatorParams{Evaluators: []string{"test_constant", "basic"}, Weights: []float64{3, 1}}, want: 0.6},
+		{name: "zero weight ignored", params: CompositeEvaluatorParams{Evaluators: []string{"test_constant", "basic"}, Weights: []float64{1, 0}}, want: 0.8},
+		{
+			name:   "latency",
+			params: CompositeEvaluatorParams{Evaluators: []string{"test_constant"}, LatencyWeight: 1, LatencyBudget: 10},
+			ec:     EvaluationContext{Latency: 5 * time.Second},
+			want:   (0.8 + 0.5) / 2,
+		},
+		{
+			name:   "no latency",
+			params: CompositeEvaluatorParams{Evaluators: []string{"test_constant"}, LatencyWeight: 1, LatencyBudget: 10},
+			want:   (0.8 + 1) / 2,
+		},
+		{
+			name:   "latency over budget",
+			params: CompositeEvaluatorParams{Evaluators: []string{"test_constant"}, LatencyWeight: 1, LatencyBudget: 10},
+			ec:     EvaluationContext{Latency: 30 * time.Second},
+			want:   0.8 / 2,
+		},
+		{
+			name:   "priority",
+			params: CompositeEvaluatorParams{Evaluators: []string{"test_constant"}, PriorityWeight: 1},
+			ec:     EvaluationContext{Priority: 0.2},
+			want:   (0.8 + 0.2) / 2,
+		},
+		{
+			name:   "priority disabled",
+			params: CompositeEvaluatorParams{Evaluators: []string{"test_constant"}},
+			ec:     EvaluationContext{Priority: 0.2},
+			want:   0.8,
+		},
+		{
+			name:   "every component",
+			params: CompositeEvaluatorParams{Evaluators: []string{"test_constant"}, Weights: []float64{1}, LatencyWeight: 1, LatencyBudget: 10, PriorityWeight: 2},
+			ec:     EvaluationContext{Latency: 2 * time.Second, Priority: 0.5},
+			want:   (0.8 + 0.8 + 2*0.5) / 4,
+		},
+		{
+			name:   "disqualified",
+			params: CompositeEvaluatorParams{Evaluators: []string{"test_constant", "test_disqualify"}, PriorityWeight: 1},
+			ec:     EvaluationContext{Priority: 1},
+			want:   -1,
+		},
+	}
+
+	// The response has no dataSet, so the basic evaluator scores it 0
+	data := map[string]interface{}{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluator, err := NewCompositeEvaluator(tt.params, iniParamsLoader(t, config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ec := tt.ec
+			if got := evaluator(data, zap.NewNop(), &ec); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("score = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
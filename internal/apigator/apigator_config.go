@@ -12,10 +12,54 @@ const (
 	defaultAPIGatorTimeout = 60 * time.Second
 )
 
-// APIGatorConfig represents basic APIGator configuration in common for every APIGatorTarget
+// APIGatorConfig represents basic APIGator configuration of an APIGatorTarget.
+// It's defined in common for every APIGatorTarget, but every setting can be
+// overridden by the group of the target or by the target itself
 type APIGatorConfig struct {
 	DatasetPath string        `ini:"dataset_path"`
 	AuthPath    string        `ini:"auth_path"`
 	GrantType   string        `ini:"grant_type"`
 	Timeout     time.Duration `ini:"timeout"`
+	// URL of the identity server for requesting the Access Tokens, when it's
+	// not the APIGator host. Example: "https://identity.exate.co"
+	AuthHost string `ini:"auth_host"`
+	// Seconds before the token expiration for refreshing it in background.
+	// A negative value disables the background refresh
+	TokenRefreshAhead time.Duration `ini:"token_refresh_ahead"`
+	// Consecutive failures opening the circuit breaker. 0 disables it
+	BreakerFailures int `ini:"breaker_failures"`
+	// Error rate (from 0.0 to 1.0) of the last 'breaker_window' requests
+	// opening the circuit breaker. 0 disables it
+	BreakerErrorRate float64 `ini:"breaker_error_rate"`
+	// Number of recent requests for computing the error rate
+	BreakerWindow int `ini:"breaker_window"`
+	// Seconds the circuit breaker stays open before probing the target again
+	BreakerCooldown time.Duration `ini:"breaker_cooldown"`
+	// Seconds between health checks of the target. A negative value disables
+	// them, and the target is always considered healthy
+	HealthCheckInterval time.Duration `ini:"health_check_interval"`
+	// Seconds a health check can take
+	HealthCheckTimeout time.Duration `ini:"health_check_timeout"`
+	// Maximum number of attempts of a request, including the first one
+	RetryMaxAttempts int `ini:"retry_max_attempts"`
+	// Status codes of the responses attempted again
+	RetryStatusCodes []int `ini:"retry_status_codes"`
+	// Retries the requests failed without a response (connection refused,
+	// timeout...)
+	RetryTransportErrors bool `ini:"retry_transport_errors"`
+	// Seconds (fractions allowed) before the first retry, doubled on every
+	// retry up to 'retry_max_backoff'
+	RetryBackoff float64 `ini:"retry_backoff"`
+	// Maximum seconds (fractions allowed) between attempts, unless the
+	// response asks for a longer Retry-After
+	RetryMaxBackoff float64 `ini:"retry_max_backoff"`
+	// Maximum seconds since the first attempt for starting a new one. 0 means
+	// no limit besides 'retry_max_attempts'
+	RetryBudget float64 `ini:"retry_budget"`
+	// Percentile (from 0.0 to 1.0) of the recent latencies of the target
+	// after which an unanswered request is also sent to an alternate host.
+	// 0 disables hedging
+	HedgePercentile float64 `ini:"hedge_percentile"`
+	// Number of recent latencies needed before hedging
+	HedgeMinSamples int `ini:"hedge_min_samples"`
 }
@@ -2,8 +2,9 @@ package apigator
 
 import (
 	"encoding/json"
+	"fmt"
 	"go.uber.org/zap"
-	"strings"
+	"time"
 )
 
 const (
@@ -11,15 +12,51 @@ const (
 	worstResponseScore = 0.0
 )
 
+// Registering the built-in evaluators
+func init() {
+	RegisterEvaluator("basic", struct{}{}, func(struct{}) (APIGatorResponseEvaluator, error) {
+		return BasicEvaluator, nil
+	})
+	RegisterEvaluator("percentage", defaultPercentEvaluatorParams, NewPercentEvaluator)
+}
+
 // APIGatorResponseEvaluator defines the structure of the functions used the
 // different strategies for evaluating which is the "best" response from
 // APIGator and return it to the original requester.
 // Each function for evaluating response must return a score from 0 to 1 where
 // higher numbers means "better" response
-type APIGatorResponseEvaluator func(data map[string]interface{}, logger *zap.Logger, restrictedText string) float64
+type APIGatorResponseEvaluator func(data map[string]interface{}, logger *zap.Logger, ec *EvaluationContext) float64
+
+// EvaluationContext holds the information about the incoming request and the
+// response under evaluation that evaluators can use besides the response body
+type EvaluationContext struct {
+	// Text used by APIGator for replacing the restricted values
+	RestrictedText string
+	// JSONPath-like selectors of the fields required by the requester
+	RequiredFields []string
+	// JSON Schema for the 'manifestName' of the request. nil if there is none
+	Schema *ResponseSchema
+	// Recognises the masked values besides the RestrictedText. nil if there
+	// is no masking configuration
+	Masking *MaskMatcher
+	// Nesting limit for walking the dataSet. 0 means the default limit
+	MaxDepth int
+	// Name of the APIGatorTarget which returned the response
+	Target string
+	// Priority of the APIGatorTarget which returned the response
+	Priority float64
+	// Time spent by the APIGatorTarget for returning the response
+	Latency time.Duration
+}
+
+// Restriction returns how restricted a value of the response is, from 0.0
+// (not masked at all) to 1.0 (fully restricted)
+func (ec *EvaluationContext) Restriction(value string) float64 {
+	return ec.Masking.Restriction(value, ec.RestrictedText)
+}
 
 // BasicEvaluator considers a response as valid if the 'dataSet' key exists or not
-func BasicEvaluator(data map[string]interface{}, logger *zap.Logger, restrictedText string) float64 {
+func BasicEvaluator(data map[string]interface{}, logger *zap.Logger, ec *EvaluationContext) float64 {
 	if _, exists := data["dataSet"]; exists {
 		return bestResponseScore
 	}
@@ -27,56 +64,41 @@ func BasicEvaluator(data map[string]interface{}, logger *zap.Logger, restrictedT
 	return worstResponseScore
 }
 
-/*
-CountStringOccurrences scans a given JSON document (represented as a map) and counts the occurrences
-of a specified string within the values of the JSON. It also counts the total number of parameters scanned.
-
-Parameters:
-- jsonMap (map[string]interface{}): The JSON document already unmarshaled into a map.
-- targetString (string): The string to search for within the values of the JSON document.
-
-Returns:
-- int: The number of times the target string appears in the JSON values.
-- int: The total number of parameters scanned in the JSON document.
-
-This function traverses the JSON document recursively to ensure all nested values are checked.
-*/
-func countObjectKeys(data map[string]interface{}, pattern string) (int, int) {
-	var count int = 0
-	var total int = 0
-
-	for _, value := range data {
-		total++
-		// Switching based on value type
-		switch v := value.(type) {
-		// if string, check the value
-		case string:
-			if strings.Contains(value.(string), pattern) {
-				count++
-			}
-		// if nested JSON, recursive calling to this function
-		case map[string]interface{}:
-			a, b := countObjectKeys(v, pattern)
-			count += a
-			total += b
-		// if embedded JSON parse the value as a new JSON doc, and continue recursive calling
-		case []interface{}:
-			for _, item := range v {
-				if subMap, ok := item.(map[string]interface{}); ok {
-					a, b := countObjectKeys(subMap, pattern)
-					count += a
-					total += b
-				}
-			}
-		}
+// PercentEvaluatorParams defines the parameters of the "percentage" evaluator,
+// configured on the "[evaluator.percentage]" section
+type PercentEvaluatorParams struct {
+	// Score for a dataSet without any field. Scoring it as a percentage would
+	// be a division by zero
+	EmptyDatasetScore float64 `ini:"empty_dataset_score"`
+}
+
+// defaultPercentEvaluatorParams are the parameters used when the "percentage"
+// evaluator has no configuration section
+var defaultPercentEvaluatorParams = PercentEvaluatorParams{
+	EmptyDatasetScore: worstResponseScore,
+}
+
+// NewPercentEvaluator returns a PercentEvaluator configured with the given parameters
+func NewPercentEvaluator(params PercentEvaluatorParams) (APIGatorResponseEvaluator, error) {
+	if params.EmptyDatasetScore < worstResponseScore || params.EmptyDatasetScore > bestResponseScore {
+		return nil, fmt.Errorf("empty_dataset_score must be between %.1f and %.1f", worstResponseScore, bestResponseScore)
 	}
 
-	return count, total
+	return func(data map[string]interface{}, logger *zap.Logger, ec *EvaluationContext) float64 {
+		return percentScore(data, logger, ec, params)
+	}, nil
 }
 
-// PercentEvaluator evaluates the percent of the fields that are de/crypted in a APIGator Response
-func PercentEvaluator(data map[string]interface{}, logger *zap.Logger, restrictedText string) float64 {
-	var dataSet map[string]interface{}
+// PercentEvaluator evaluates the percent of the leaf values that are
+// de/crypted in a APIGator Response, including the ones of embedded JSON
+// documents
+func PercentEvaluator(data map[string]interface{}, logger *zap.Logger, ec *EvaluationContext) float64 {
+	return percentScore(data, logger, ec, defaultPercentEvaluatorParams)
+}
+
+// percentScore implements the PercentEvaluator using the given parameters
+func percentScore(data map[string]interface{}, logger *zap.Logger, ec *EvaluationContext, params PercentEvaluatorParams) float64 {
+	var dataSet interface{}
 
 	dataBytes := []byte(data["dataSet"].(string))
 	err := json.Unmarshal(dataBytes, &dataSet)
@@ -85,8 +107,13 @@ func PercentEvaluator(data map[string]interface{}, logger *zap.Logger, restricte
 		return -1.0
 	}
 
-	count, total := countObjectKeys(dataSet, restrictedText)
-	score := float64(count) / float64(total)
+	report := InspectLeaves(dataSet, ec)
+	logger.Debug("Restricted leaves per path", zap.String("apigator", ec.Target), zap.Any("paths", report))
+	count, total := report.Totals()
+	if total == 0 {
+		return params.EmptyDatasetScore
+	}
+	score := count / float64(total)
 
 	// The returned value is 1-score because less crypted values increases the final score of the response
 	return 1 - score
@@ -0,0 +1,188 @@
+package apigator
+
+import (
+	"context"
+	"exate-dora-router/internal/metrics"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHealthCheckInterval is the time between probes of a target when
+	// 'health_check_interval' is not configured
+	defaultHealthCheckInterval = 30 * time.Second
+	// defaultHealthCheckTimeout is the time a probe can take when
+	// 'health_check_timeout' is not configured
+	defaultHealthCheckTimeout = 5 * time.Second
+)
+
+// HealthState describes the result of the last probe of an APIGatorTarget
+type HealthState struct {
+	// The target is reachable and it provides Access Tokens
+	Healthy bool `json:"healthy"`
+	// Health checks are disabled for the target, so it's always healthy
+	Unchecked     bool       `json:"unchecked,omitempty"`
+	Reachable     bool       `json:"reachable"`
+	Authenticated bool       `json:"authenticated"`
+	LatencyMS     int64      `json:"latency_ms"`
+	LastCheck     *time.Time `json:"last_check,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	// Number of failed probes since the last successful one
+	ConsecutiveFailures int `json:"consecutive_failures"`
+}
+
+// HealthChecker probes periodically the identity endpoint of an
+// APIGatorTarget, measuring its reachability and latency, and checks that
+// the target provides Access Tokens
+type HealthChecker struct {
+	target   *APIGatorTarget
+	interval time.Duration
+	timeout  time.Duration
+
+	mu    sync.Mutex
+	state HealthState
+	stop  chan struct{}
+}
+
+// NewHealthChecker returns the HealthChecker for an APIGatorTarget. 0
+// 'interval' or 'timeout' means their default values, and a negative
+// 'interval' disables the checks. Checks don't start until Start is called
+func NewHealthChecker(target *APIGatorTarget, interval time.Duration, timeout time.Duration) *HealthChecker {
+	if interval == 0 {
+		interval = defaultHealthCheckInterval
+	}
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	h := &HealthChecker{target: target, interval: interval, timeout: timeout}
+	if interval < 0 {
+		h.state = HealthState{Healthy: true, Unchecked: true}
+	}
+	return h
+}
+
+// Start probes the target right away and then every interval, until Stop is
+// called
+func (h *HealthChecker) Start() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.interval < 0 || h.stop != nil {
+		return
+	}
+	h.stop = make(chan struct{})
+	go h.run(h.stop)
+}
+
+// Stop stops the periodic probes
+func (h *HealthChecker) Stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.stop != nil {
+		close(h.stop)
+		h.stop = nil
+	}
+}
+
+// State returns the result of the last probe
+func (h *HealthChecker) State() HealthState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.state
+}
+
+// run probes the target until 'stop' is closed
+func (h *HealthChecker) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		h.check()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check probes the target and saves the result
+func (h *HealthChecker) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	state := h.target.probe(ctx)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if state.Healthy {
+		state.ConsecutiveFailures = 0
+	} else {
+		state.ConsecutiveFailures = h.state.ConsecutiveFailures + 1
+	}
+	if state.Healthy != h.state.Healthy || h.state.LastCheck == nil {
+		h.target.Logger.Info("APIGator health changed",
+			zap.String("apigator_target", h.target.Name),
+			zap.Bool("healthy", state.Healthy),
+			zap.String("error", state.LastError),
+		)
+	}
+	h.state = state
+	metrics.SetTargetHealth(h.target.Name, state.Healthy, time.Duration(state.LatencyMS)*time.Millisecond)
+}
+
+// inherit takes the last result of the HealthChecker of the same target on a
+// previous configuration, so the target keeps its health until its first probe
+func (h *HealthChecker) inherit(previous *HealthChecker) {
+	state := previous.State()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.interval > 0 && !state.Unchecked {
+		h.state = state
+	}
+}
+
+// probe checks that the identity endpoint of the target answers, measuring
+// its latency, and that the target provides an Access Token. Any response
+// but a 5xx means the endpoint is reachable. A new token is requested on every
+// probe, so credentials rejected by the identity provider make the target
+// unhealthy even while its current token is valid
+func (a *APIGatorTarget) probe(ctx context.Context) HealthState {
+	now := time.Now()
+	state := HealthState{LastCheck: &now}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", a.authURL(), nil)
+	if err != nil {
+		state.LastError = err.Error()
+		return state
+	}
+	start := time.Now()
+	resp, err := a.Client.Do(req)
+	state.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		state.LastError = err.Error()
+		return state
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		state.LastError = fmt.Sprintf("identity endpoint returned status code %d", resp.StatusCode)
+		return state
+	}
+	state.Reachable = true
+
+	if err := a.Tokens.Renew(ctx); err != nil {
+		state.LastError = fmt.Sprintf("failed to obtain an Access Token: %v", err)
+		return state
+	}
+	state.Authenticated = true
+	state.Healthy = true
+	return state
+}
@@ -0,0 +1,114 @@
+package apigator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestHealthTarget starts an identity server answering the probes with
+// 'probeStatus' and the token requests with 'tokenStatus', which can be
+// changed while the server runs
+func newTestHealthTarget(t *testing.T, probeStatus int, tokenStatus *atomic.Int64) *APIGatorTarget {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.WriteHeader(probeStatus)
+			return
+		}
+		status := int(tokenStatus.Load())
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			fmt.Fprint(w, `{"access_token":"token","expires_in":3600}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	target := newTestTarget(t, server.URL)
+	target.Tokens = NewTokenManager(target, -1)
+	return target
+}
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name          string
+		probeStatus   int
+		tokenStatus   int
+		cachedToken   bool
+		reachable     bool
+		authenticated bool
+	}{
+		{name: "healthy", probeStatus: http.StatusMethodNotAllowed, tokenStatus: http.StatusOK, reachable: true, authenticated: true},
+		{name: "unauthenticated probe is reachable", probeStatus: http.StatusUnauthorized, tokenStatus: http.StatusOK, reachable: true, authenticated: true},
+		{name: "identity endpoint failing", probeStatus: http.StatusServiceUnavailable, tokenStatus: http.StatusOK},
+		{name: "credentials rejected", probeStatus: http.StatusOK, tokenStatus: http.StatusUnauthorized, reachable: true},
+		{name: "client forbidden", probeStatus: http.StatusOK, tokenStatus: http.StatusForbidden, reachable: true},
+		{name: "credentials rejected with a valid token", probeStatus: http.StatusOK, tokenStatus: http.StatusUnauthorized, cachedToken: true, reachable: true},
+		{name: "token request failing", probeStatus: http.StatusOK, tokenStatus: http.StatusInternalServerError, reachable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tokenStatus atomic.Int64
+			tokenStatus.Store(http.StatusOK)
+			target := newTestHealthTarget(t, tt.probeStatus, &tokenStatus)
+			if tt.cachedToken {
+				if _, err := target.Tokens.Token(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tokenStatus.Store(int64(tt.tokenStatus))
+
+			state := target.probe(context.Background())
+			if state.Reachable != tt.reachable || state.Authenticated != tt.authenticated {
+				t.Errorf("probe() = %+v, want reachable %v and authenticated %v", state, tt.reachable, tt.authenticated)
+			}
+			healthy := tt.reachable && tt.authenticated
+			if state.Healthy != healthy || (state.LastError == "") != healthy {
+				t.Errorf("probe() = %+v, want healthy %v", state, healthy)
+			}
+			if state.LastCheck == nil {
+				t.Error("probe() has no LastCheck")
+			}
+		})
+	}
+}
+
+func TestHealthChecker(t *testing.T) {
+	var tokenStatus atomic.Int64
+	tokenStatus.Store(http.StatusOK)
+	target := newTestHealthTarget(t, http.StatusOK, &tokenStatus)
+
+	h := NewHealthChecker(target, time.Hour, time.Second)
+	if state := h.State(); state.Healthy || state.LastCheck != nil {
+		t.Errorf("State() before the first check = %+v", state)
+	}
+
+	h.check()
+	if state := h.State(); !state.Healthy || state.ConsecutiveFailures != 0 {
+		t.Errorf("State() after a successful check = %+v", state)
+	}
+
+	tokenStatus.Store(http.StatusUnauthorized)
+	h.check()
+	h.check()
+	if state := h.State(); state.Healthy || state.ConsecutiveFailures != 2 {
+		t.Errorf("State() after two failed checks = %+v", state)
+	}
+
+	tokenStatus.Store(http.StatusOK)
+	h.check()
+	if state := h.State(); !state.Healthy || state.ConsecutiveFailures != 0 {
+		t.Errorf("State() after recovering = %+v", state)
+	}
+
+	unchecked := NewHealthChecker(target, -1, 0)
+	if state := unchecked.State(); !state.Healthy || !state.Unchecked || state.LastCheck != nil {
+		t.Errorf("State() with the checks disabled = %+v", state)
+	}
+}
@@ -0,0 +1,200 @@
+package apigator
+
+import (
+	"bytes"
+	"context"
+	"exate-dora-router/internal/metrics"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// latencyWindow is the number of recent latencies kept for every target
+	latencyWindow = 100
+	// defaultHedgeMinSamples is the number of latencies needed before hedging
+	// when 'hedge_min_samples' is not configured
+	defaultHedgeMinSamples = 20
+)
+
+// LatencyTracker keeps the latencies of the last requests to a host of an
+// APIGatorTarget, for deciding when a request is slow enough to be hedged. It
+// can be used concurrently
+type LatencyTracker struct {
+	minSamples int
+
+	mu sync.Mutex
+	// Latencies of the last requests, as a ring buffer
+	samples []time.Duration
+	next    int
+	count   int
+}
+
+// NewLatencyTracker returns a LatencyTracker whose percentiles are known once
+// it has 'minSamples' latencies. 0 'minSamples' means its default value
+func NewLatencyTracker(minSamples int) *LatencyTracker {
+	if minSamples == 0 {
+		minSamples = defaultHedgeMinSamples
+	}
+	if minSamples > latencyWindow {
+		minSamples = latencyWindow
+	}
+	return &LatencyTracker{minSamples: minSamples, samples: make([]time.Duration, latencyWindow)}
+}
+
+// Observe records the latency of a request
+func (l *LatencyTracker) Observe(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.samples[l.next] = latency
+	l.next = (l.next + 1) % len(l.samples)
+	if l.count < len(l.samples) {
+		l.count++
+	}
+}
+
+// Percentile returns the latency below which the fraction 'p' of the recent
+// requests were answered. It reports false until there are enough latencies
+func (l *LatencyTracker) Percentile(p float64) (time.Duration, bool) {
+	l.mu.Lock()
+	sorted := append([]time.Duration(nil), l.samples[:l.count]...)
+	l.mu.Unlock()
+
+	if len(sorted) == 0 || len(sorted) < l.minSamples {
+		return 0, false
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i], true
+}
+
+// inherit takes the latencies of the LatencyTracker of the same host on a
+// previous configuration, so hedging doesn't wait for new samples
+func (l *LatencyTracker) inherit(previous *LatencyTracker) {
+	previous.mu.Lock()
+	defer previous.mu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	copy(l.samples, previous.samples)
+	l.next = previous.next
+	l.count = previous.count
+}
+
+// attemptResult is the result of an attempt sent to one of the hosts of an
+// APIGatorTarget
+type attemptResult struct {
+	resp  *http.Response
+	token string
+	err   error
+	hedge bool
+}
+
+// hedgeDelay returns the time after which an attempt still unanswered is sent
+// to an alternate host. It reports false if hedging is disabled or there are
+// not enough latencies yet
+func (a *APIGatorTarget) hedgeDelay() (time.Duration, bool) {
+	if a.Config.HedgePercentile <= 0 || len(a.AlternateHosts) == 0 {
+		return 0, false
+	}
+	return a.Latencies[a.Host].Percentile(a.Config.HedgePercentile)
+}
+
+// observeLatency records the latency of a request sent to 'host'
+func (a *APIGatorTarget) observeLatency(host string, latency time.Duration) {
+	if tracker, ok := a.Latencies[host]; ok {
+		tracker.Observe(latency)
+	}
+}
+
+// alternateHost returns the next alternate host for hedging, in turns
+func (a *APIGatorTarget) alternateHost() string {
+	a.hedgeMu.Lock()
+	defer a.hedgeMu.Unlock()
+
+	host := a.AlternateHosts[a.hedgeNext%len(a.AlternateHosts)]
+	a.hedgeNext++
+	return host
+}
+
+// sendHedged performs a single attempt like send. If the host of the target
+// doesn't answer within the 'hedge_percentile' of its recent latencies, the
+// same request is sent to an alternate host. The first successful response
+// (any status code but 5xx) is returned and the other request is cancelled.
+// The body of the returned response is already read
+func (a *APIGatorTarget) sendHedged(ctx context.Context, body []byte, attempt int) (*http.Response, string, error) {
+	delay, hedging := a.hedgeDelay()
+	if !hedging {
+		return a.send(ctx, a.Host, body, attempt)
+	}
+
+	// Cancelling the context on return aborts the request that didn't win
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan attemptResult, 2)
+	launch := func(host string, hedge bool) {
+		go func() {
+			resp, token, err := a.send(ctx, host, body, attempt)
+			if err == nil {
+				err = readBody(resp)
+			}
+			results <- attemptResult{resp: resp, token: token, err: err, hedge: hedge}
+		}()
+	}
+	launch(a.Host, false)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	pending, hedged := 1, false
+	var last attemptResult
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			host := a.alternateHost()
+			a.Logger.Debug("Hedging slow request to an alternate APIGator host",
+				zap.String("apigator_target", a.Name),
+				zap.String("host", host),
+				zap.Duration("delay", delay),
+			)
+			launch(host, true)
+			pending++
+			hedged = true
+		case result := <-results:
+			pending--
+			last = result
+			if result.err == nil && result.resp.StatusCode < http.StatusInternalServerError {
+				if hedged {
+					metrics.ObserveHedge(a.Name, result.hedge)
+				}
+				return result.resp, result.token, nil
+			}
+			// A failed request before the hedging delay is left to the retry policy
+			if !hedged {
+				return result.resp, result.token, result.err
+			}
+		}
+	}
+	return last.resp, last.token, last.err
+}
+
+// readBody reads the whole body of a response, so it can still be read
+// after the request context is cancelled
+func readBody(resp *http.Response) error {
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
+	return nil
+}
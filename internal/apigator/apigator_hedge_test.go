@@ -0,0 +1,284 @@
+package apigator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLatencyTrackerPercentile(t *testing.T) {
+	// milliseconds observes 'from' to 'to' milliseconds
+	milliseconds := func(from, to int) []time.Duration {
+		var latencies []time.Duration
+		for ms := from; ms <= to; ms++ {
+			latencies = append(latencies, time.Duration(ms)*time.Millisecond)
+		}
+		return latencies
+	}
+
+	tests := []struct {
+		name       string
+		minSamples int
+		latencies  []time.Duration
+		p          float64
+		want       time.Duration
+		ok         bool
+	}{
+		{name: "no latencies", minSamples: 1, p: 0.5},
+		{name: "not enough latencies", minSamples: 20, latencies: milliseconds(1, 19), p: 0.5},
+		{name: "default minimum samples", latencies: milliseconds(1, 19), p: 0.5},
+		{name: "enough latencies", minSamples: 20, latencies: milliseconds(1, 20), p: 0.5, want: 10 * time.Millisecond, ok: true},
+		{name: "unsorted latencies", minSamples: 1, latencies: []time.Duration{30, 10, 20}, p: 0.5, want: 20, ok: true},
+		{name: "95th percentile", minSamples: 1, latencies: milliseconds(1, 100), p: 0.95, want: 95 * time.Millisecond, ok: true},
+		{name: "maximum", minSamples: 1, latencies: milliseconds(1, 100), p: 1, want: 100 * time.Millisecond, ok: true},
+		{name: "minimum", minSamples: 1, latencies: milliseconds(1, 100), p: 0, want: time.Millisecond, ok: true},
+		{name: "only the last latencies are kept", minSamples: 1, latencies: milliseconds(1, 150), p: 0, want: 51 * time.Millisecond, ok: true},
+		{name: "minimum samples capped to the window", minSamples: 500, latencies: milliseconds(1, 100), p: 1, want: 100 * time.Millisecond, ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLatencyTracker(tt.minSamples)
+			for _, latency := range tt.latencies {
+				l.Observe(latency)
+			}
+			got, ok := l.Percentile(tt.p)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("Percentile(%v) = %v, %v, want %v, %v", tt.p, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLatencyTrackerInherit(t *testing.T) {
+	previous := NewLatencyTracker(2)
+	previous.Observe(10)
+	previous.Observe(20)
+
+	l := NewLatencyTracker(2)
+	l.inherit(previous)
+	l.Observe(30)
+	if got, ok := l.Percentile(1); got != 30 || !ok {
+		t.Errorf("Percentile(1) = %v, %v, want 30, true", got, ok)
+	}
+	if got, ok := l.Percentile(0); got != 10 || !ok {
+		t.Errorf("Percentile(0) = %v, %v, want 10, true", got, ok)
+	}
+}
+
+// testHost is an APIGator host answering the dataset requests after 'delay'
+// with 'status'
+type testHost struct {
+	delay  time.Duration
+	status int
+}
+
+// testHostRequests counts the dataset requests received by a testHost and
+// the ones cancelled before their answer
+type testHostRequests struct {
+	received  atomic.Int64
+	cancelled atomic.Int64
+}
+
+// start runs the host, which also provides the Access Tokens
+func (h testHost) start(t *testing.T) (string, *testHostRequests) {
+	t.Helper()
+
+	requests := &testHostRequests{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == defaultAuthPath {
+			fmt.Fprint(w, `{"access_token":"token"}`)
+			return
+		}
+		requests.received.Add(1)
+		// Reading the body lets the server notice the client cancelling
+		io.ReadAll(r.Body)
+		select {
+		case <-time.After(h.delay):
+		case <-r.Context().Done():
+			requests.cancelled.Add(1)
+			return
+		}
+		w.WriteHeader(h.status)
+		fmt.Fprint(w, r.Host)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, requests
+}
+
+// observed returns the number of latencies recorded by a LatencyTracker and
+// the last one
+func (l *LatencyTracker) observed() (int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.count, l.samples[(l.next+len(l.samples)-1)%len(l.samples)]
+}
+
+// eventually waits up to a second for 'condition' to be true
+func eventually(t *testing.T, condition func() bool) bool {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSendHedged(t *testing.T) {
+	const hedgeDelay = 50 * time.Millisecond
+
+	tests := []struct {
+		name string
+		// Latency samples of the primary host
+		samples   int
+		primary   testHost
+		alternate testHost
+		// Cancelling the caller's context after this time, if not 0
+		cancelAfter time.Duration
+		winner      string // "primary" or "alternate"
+		status      int
+		wantErr     error
+		// Requests received by the alternate host
+		hedged bool
+	}{
+		{
+			name:      "not enough latencies",
+			primary:   testHost{delay: 2 * hedgeDelay, status: http.StatusOK},
+			alternate: testHost{status: http.StatusOK},
+			winner:    "primary",
+			status:    http.StatusOK,
+		},
+		{
+			name:      "primary answers before the hedging delay",
+			samples:   1,
+			primary:   testHost{status: http.StatusOK},
+			alternate: testHost{status: http.StatusOK},
+			winner:    "primary",
+			status:    http.StatusOK,
+		},
+		{
+			name:      "hedge wins",
+			samples:   1,
+			primary:   testHost{delay: time.Hour, status: http.StatusOK},
+			alternate: testHost{status: http.StatusOK},
+			winner:    "alternate",
+			status:    http.StatusOK,
+			hedged:    true,
+		},
+		{
+			name:      "primary wins after hedging",
+			samples:   1,
+			primary:   testHost{delay: 2 * hedgeDelay, status: http.StatusOK},
+			alternate: testHost{delay: time.Hour, status: http.StatusOK},
+			winner:    "primary",
+			status:    http.StatusOK,
+			hedged:    true,
+		},
+		{
+			name:      "failed hedge waits for the primary",
+			samples:   1,
+			primary:   testHost{delay: 2 * hedgeDelay, status: http.StatusNotFound},
+			alternate: testHost{status: http.StatusServiceUnavailable},
+			winner:    "primary",
+			status:    http.StatusNotFound,
+			hedged:    true,
+		},
+		{
+			name:      "both fail",
+			samples:   1,
+			primary:   testHost{delay: 2 * hedgeDelay, status: http.StatusBadGateway},
+			alternate: testHost{status: http.StatusServiceUnavailable},
+			winner:    "primary",
+			status:    http.StatusBadGateway,
+			hedged:    true,
+		},
+		{
+			name:        "caller cancels both",
+			samples:     1,
+			primary:     testHost{delay: time.Hour, status: http.StatusOK},
+			alternate:   testHost{delay: time.Hour, status: http.StatusOK},
+			cancelAfter: 2 * hedgeDelay,
+			wantErr:     context.Canceled,
+			hedged:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primaryHost, primaryRequests := tt.primary.start(t)
+			alternateHost, alternateRequests := tt.alternate.start(t)
+
+			target := newTestTarget(t, primaryHost)
+			target.Tokens = NewTokenManager(target, -1)
+			target.AlternateHosts = []string{alternateHost}
+			target.Config.HedgePercentile = 0.5
+			target.Latencies = map[string]*LatencyTracker{
+				primaryHost:   NewLatencyTracker(1),
+				alternateHost: NewLatencyTracker(1),
+			}
+			for i := 0; i < tt.samples; i++ {
+				target.Latencies[primaryHost].Observe(hedgeDelay)
+			}
+
+			ctx := context.Background()
+			if tt.cancelAfter > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithCancel(ctx)
+				time.AfterFunc(tt.cancelAfter, cancel)
+			}
+
+			resp, _, err := target.sendHedged(ctx, []byte("{}"), 0)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("sendHedged() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				body, _ := io.ReadAll(resp.Body)
+				winner := map[string]string{primaryHost: "primary", alternateHost: "alternate"}["http://"+string(body)]
+				if winner != tt.winner || resp.StatusCode != tt.status {
+					t.Errorf("sendHedged() answered by %q with %d, want %q with %d", winner, resp.StatusCode, tt.winner, tt.status)
+				}
+			}
+			if hedged := alternateRequests.received.Load() > 0; hedged != tt.hedged {
+				t.Errorf("hedged = %v, want %v", hedged, tt.hedged)
+			}
+
+			// The request that didn't win is cancelled, and its latency is
+			// recorded on its own host at least as the time it was waiting
+			if tt.primary.delay == time.Hour {
+				if !eventually(t, func() bool { return primaryRequests.cancelled.Load() == 1 }) {
+					t.Error("primary request not cancelled")
+				}
+			}
+			if tt.alternate.delay == time.Hour && tt.hedged {
+				if !eventually(t, func() bool { return alternateRequests.cancelled.Load() == 1 }) {
+					t.Error("hedged request not cancelled")
+				}
+			}
+			wantPrimary := tt.samples + 1
+			if !eventually(t, func() bool { n, _ := target.Latencies[primaryHost].observed(); return n == wantPrimary }) {
+				n, _ := target.Latencies[primaryHost].observed()
+				t.Errorf("primary host latencies = %d, want %d", n, wantPrimary)
+			}
+			wantAlternate := 0
+			if tt.hedged {
+				wantAlternate = 1
+			}
+			if !eventually(t, func() bool { n, _ := target.Latencies[alternateHost].observed(); return n == wantAlternate }) {
+				n, _ := target.Latencies[alternateHost].observed()
+				t.Errorf("alternate host latencies = %d, want %d", n, wantAlternate)
+			}
+			if _, last := target.Latencies[primaryHost].observed(); tt.primary.delay == time.Hour && last < hedgeDelay {
+				t.Errorf("cancelled primary recorded %v, want at least the hedging delay %v", last, hedgeDelay)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package apigator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPathStep is one of the steps of a JSONPath selector. It selects a key
+// of an object, a position of an array, or every child of both when wildcard
+// is true
+type jsonPathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// JSONPath is a compiled JSONPath-like selector. The supported syntax is a
+// subset of JSONPath: the root "$", child keys (".key" or "['key']"), array
+// positions ("[0]") and wildcards (".*" or "[*]"). Example:
+// "$.employees.employee[*].email"
+type JSONPath struct {
+	expr  string
+	steps []jsonPathStep
+}
+
+// CompileJSONPath parses a JSONPath-like selector
+func CompileJSONPath(expr string) (*JSONPath, error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("invalid JSONPath %q: it must start with '$'", expr)
+	}
+
+	path := &JSONPath{expr: expr}
+	rest := expr[1:]
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			if strings.HasPrefix(rest, ".") {
+				return nil, fmt.Errorf("invalid JSONPath %q: recursive descent is not supported", expr)
+			}
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			key := rest[:end]
+			if key == "" {
+				return nil, fmt.Errorf("invalid JSONPath %q: empty key", expr)
+			}
+			path.steps = append(path.steps, jsonPathStep{key: key, wildcard: key == "*"})
+			rest = rest[end:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("invalid JSONPath %q: unclosed '['", expr)
+			}
+			selector := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			switch {
+			case selector == "*":
+				path.steps = append(path.steps, jsonPathStep{wildcard: true})
+			case len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0]:
+				path.steps = append(path.steps, jsonPathStep{key: selector[1 : len(selector)-1]})
+			default:
+				index, err := strconv.Atoi(selector)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("invalid JSONPath %q: unsupported selector [%s]", expr, selector)
+				}
+				path.steps = append(path.steps, jsonPathStep{index: index, isIndex: true})
+			}
+		default:
+			return nil, fmt.Errorf("invalid JSONPath %q: unexpected character %q", expr, rest[0])
+		}
+	}
+
+	return path, nil
+}
+
+// String returns the expression of the selector
+func (p *JSONPath) String() string {
+	return p.expr
+}
+
+// Select returns every node of the document matched by the selector
+func (p *JSONPath) Select(doc interface{}) []interface{} {
+	nodes := []interface{}{doc}
+	for _, step := range p.steps {
+		var next []interface{}
+		for _, node := range nodes {
+			switch n := node.(type) {
+			case map[string]interface{}:
+				if step.wildcard {
+					for _, value := range n {
+						next = append(next, value)
+					}
+				} else if value, exists := n[step.key]; exists && !step.isIndex {
+					next = append(next, value)
+				}
+			case []interface{}:
+				if step.wildcard {
+					next = append(next, n...)
+				} else if step.isIndex && step.index < len(n) {
+					next = append(next, n[step.index])
+				}
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
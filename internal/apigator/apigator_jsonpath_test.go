@@ -0,0 +1,94 @@
+package apigator
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCompileJSONPath(t *testing.T) {
+	tests := []struct {
+		expr      string
+		wantSteps []jsonPathStep
+		wantErr   bool
+	}{
+		{expr: "$"},
+		{expr: " $.a ", wantSteps: []jsonPathStep{{key: "a"}}},
+		{expr: "$.a.b", wantSteps: []jsonPathStep{{key: "a"}, {key: "b"}}},
+		{expr: "$['a']", wantSteps: []jsonPathStep{{key: "a"}}},
+		{expr: `$["a.b"]`, wantSteps: []jsonPathStep{{key: "a.b"}}},
+		{expr: "$.l[2]", wantSteps: []jsonPathStep{{key: "l"}, {index: 2, isIndex: true}}},
+		{expr: "$.*", wantSteps: []jsonPathStep{{key: "*", wildcard: true}}},
+		{expr: "$.l[*].e", wantSteps: []jsonPathStep{{key: "l"}, {wildcard: true}, {key: "e"}}},
+		{expr: "a.b", wantErr: true},
+		{expr: "$..a", wantErr: true},
+		{expr: "$.", wantErr: true},
+		{expr: "$.a.", wantErr: true},
+		{expr: "$[0", wantErr: true},
+		{expr: "$[-1]", wantErr: true},
+		{expr: "$[?(@.a)]", wantErr: true},
+		{expr: "$a", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			path, err := CompileJSONPath(tt.expr)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got steps %+v", path.steps)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(path.steps, tt.wantSteps) {
+				t.Errorf("steps = %+v, want %+v", path.steps, tt.wantSteps)
+			}
+		})
+	}
+}
+
+func TestJSONPathSelect(t *testing.T) {
+	const doc = `{"a":{"b":"x","c":["y","z"]},"l":[{"e":"1"},{"e":"2"},{"f":"3"}]}`
+
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{expr: "$.a.b", want: []string{`"x"`}},
+		{expr: "$['a']['c'][1]", want: []string{`"z"`}},
+		{expr: "$.a.c[*]", want: []string{`"y"`, `"z"`}},
+		{expr: "$.l[*].e", want: []string{`"1"`, `"2"`}},
+		{expr: "$.a.*", want: []string{`"x"`, `["y","z"]`}},
+		{expr: "$.a.c[5]", want: nil},
+		{expr: "$.missing", want: nil},
+		{expr: "$.a.b.c", want: nil},
+		{expr: "$.a[0]", want: nil},
+		{expr: "$.l.e", want: nil},
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(doc), &value); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			path, err := CompileJSONPath(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, node := range path.Select(value) {
+				encoded, _ := json.Marshal(node)
+				got = append(got, string(encoded))
+			}
+			// Object wildcards have no order
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Select = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
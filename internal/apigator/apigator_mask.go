@@ -0,0 +1,157 @@
+package apigator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// defaultMinMaskRun is the minimum number of consecutive mask characters
+	// recognised as a mask when 'min_mask_run' is not configured
+	defaultMinMaskRun = 3
+)
+
+// MaskingConfig defines how to recognise the values masked by APIGator
+// besides the 'restrictedText' of the request. Configured on the "[masking]"
+// INI section
+type MaskingConfig struct {
+	// Characters used by APIGator for masking values (e.g. "*#")
+	MaskCharacters string `ini:"mask_characters"`
+	// Minimum number of consecutive mask characters recognised as a mask. A
+	// value made only of mask characters is always a mask, so length
+	// preserved masks of any size are recognised
+	MinMaskRun int `ini:"min_mask_run"`
+	// Regular expressions matching masked text
+	Patterns []string `ini:"patterns" delim:","`
+	// If true, partially masked values count as a fraction of a restricted
+	// value, proportional to the masked characters. If false, any mask makes
+	// the whole value restricted
+	PartialCredit bool `ini:"partial_credit"`
+}
+
+// DefaultMaskingConfig returns the MaskingConfig with the default values
+func DefaultMaskingConfig() MaskingConfig {
+	return MaskingConfig{
+		MinMaskRun:    defaultMinMaskRun,
+		PartialCredit: true,
+	}
+}
+
+// MaskMatcher recognises the masked values on APIGator responses
+type MaskMatcher struct {
+	maskCharacters string
+	minMaskRun     int
+	patterns       []*regexp.Regexp
+	partialCredit  bool
+}
+
+// NewMaskMatcher compiles a MaskingConfig into a MaskMatcher
+func NewMaskMatcher(config MaskingConfig) (*MaskMatcher, error) {
+	if config.MinMaskRun < 1 {
+		return nil, fmt.Errorf("min_mask_run must be greater than 0")
+	}
+
+	m := &MaskMatcher{
+		maskCharacters: config.MaskCharacters,
+		minMaskRun:     config.MinMaskRun,
+		partialCredit:  config.PartialCredit,
+	}
+	for _, pattern := range config.Patterns {
+		if strings.TrimSpace(pattern) == "" {
+			continue
+		}
+		re, err := regexp.Compile(strings.TrimSpace(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid mask pattern %q: %v", pattern, err)
+		}
+		m.patterns = append(m.patterns, re)
+	}
+
+	return m, nil
+}
+
+// Restriction returns how restricted a value is, from 0.0 (not masked at all)
+// to 1.0 (fully restricted). The masked parts of the value are the
+// occurrences of the restrictedText, the matches of the mask patterns and the
+// runs of mask characters. Without partial credit, or on a nil MaskMatcher,
+// any masked part makes the value fully restricted
+func (m *MaskMatcher) Restriction(value string, restrictedText string) float64 {
+	if value == "" {
+		return 0.0
+	}
+
+	// Marking the masked bytes of the value
+	masked := make([]bool, len(value))
+	mark := func(start, end int) {
+		for i := start; i < end; i++ {
+			masked[i] = true
+		}
+	}
+
+	if restrictedText != "" {
+		for offset := 0; offset < len(value); {
+			i := strings.Index(value[offset:], restrictedText)
+			if i == -1 {
+				break
+			}
+			mark(offset+i, offset+i+len(restrictedText))
+			offset += i + len(restrictedText)
+		}
+	} else {
+		// Keeping the behaviour of strings.Contains with an empty pattern
+		mark(0, len(value))
+	}
+
+	if m != nil {
+		for _, re := range m.patterns {
+			for _, loc := range re.FindAllStringIndex(value, -1) {
+				mark(loc[0], loc[1])
+			}
+		}
+		if m.maskCharacters != "" {
+			m.markMaskRuns(value, mark)
+		}
+	}
+
+	// Counting masked characters
+	var maskedChars, totalChars int
+	for i := range value {
+		totalChars++
+		if masked[i] {
+			maskedChars++
+		}
+	}
+
+	if maskedChars == 0 {
+		return 0.0
+	}
+	if m == nil || !m.partialCredit {
+		return 1.0
+	}
+	return float64(maskedChars) / float64(totalChars)
+}
+
+// markMaskRuns marks the runs of mask characters long enough to be a mask.
+// A run covering the whole value is always a mask
+func (m *MaskMatcher) markMaskRuns(value string, mark func(start, end int)) {
+	runStart, runLength := -1, 0
+	flush := func(end int) {
+		if runStart != -1 && (runLength >= m.minMaskRun || (runStart == 0 && end == len(value))) {
+			mark(runStart, end)
+		}
+		runStart, runLength = -1, 0
+	}
+
+	for i, c := range value {
+		if strings.ContainsRune(m.maskCharacters, c) {
+			if runStart == -1 {
+				runStart = i
+			}
+			runLength++
+		} else {
+			flush(i)
+		}
+	}
+	flush(len(value))
+}
@@ -0,0 +1,72 @@
+package apigator
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewMaskMatcher(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  MaskingConfig
+		wantErr bool
+	}{
+		{name: "defaults", config: DefaultMaskingConfig()},
+		{name: "blank patterns are skipped", config: MaskingConfig{MinMaskRun: 1, Patterns: []string{" ", "X+"}}},
+		{name: "zero min_mask_run", config: MaskingConfig{}, wantErr: true},
+		{name: "invalid pattern", config: MaskingConfig{MinMaskRun: 1, Patterns: []string{"("}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMaskMatcher(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMaskMatcherRestriction(t *testing.T) {
+	partial := MaskingConfig{MaskCharacters: "*#", MinMaskRun: 3, Patterns: []string{`\[REDACTED\]`}, PartialCredit: true}
+	whole := partial
+	whole.PartialCredit = false
+
+	tests := []struct {
+		name           string
+		config         *MaskingConfig
+		value          string
+		restrictedText string
+		want           float64
+	}{
+		{name: "empty value", config: &partial, value: "", restrictedText: "*****", want: 0},
+		{name: "nil matcher without mask", value: "John", restrictedText: "*****", want: 0},
+		{name: "nil matcher with restrictedText", value: "ab*****", restrictedText: "*****", want: 1},
+		{name: "nil matcher with empty restrictedText", value: "John", want: 1},
+		{name: "restrictedText only", config: &partial, value: "*****", restrictedText: "*****", want: 1},
+		{name: "partial restrictedText", config: &partial, value: "ab*****cde", restrictedText: "*****", want: 0.5},
+		{name: "pattern", config: &partial, value: "[REDACTED]", restrictedText: "*****", want: 1},
+		{name: "length preserved mask", config: &partial, value: "##", restrictedText: "*****", want: 1},
+		{name: "short run is not a mask", config: &partial, value: "a**b", restrictedText: "*****", want: 0},
+		{name: "partial run", config: &partial, value: "1234####", restrictedText: "*****", want: 0.5},
+		{name: "multibyte characters", config: &partial, value: "éé##", restrictedText: "*****", want: 0},
+		{name: "multibyte characters with run", config: &partial, value: "éé###", restrictedText: "*****", want: 0.6},
+		{name: "without partial credit", config: &whole, value: "1234####", restrictedText: "*****", want: 1},
+		{name: "without mask", config: &whole, value: "1234", restrictedText: "*****", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m *MaskMatcher
+			if tt.config != nil {
+				var err error
+				if m, err = NewMaskMatcher(*tt.config); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := m.Restriction(tt.value, tt.restrictedText); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Restriction(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
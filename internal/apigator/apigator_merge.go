@@ -0,0 +1,161 @@
+package apigator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+const (
+	// provenanceKey is the key added to the merged response body with the
+	// target which supplied each value of the dataSet
+	provenanceKey = "provenance"
+)
+
+// mergeNode is the value of the same node of the dataSet on a candidate
+// response, and the name of the APIGatorTarget which returned it
+type mergeNode struct {
+	value  interface{}
+	source string
+}
+
+// MergeResponses assembles a single response from several APIGator responses
+// for the same request. The candidates must be sorted by preference: the first
+// one defines the structure of the merged dataSet and the non-dataSet fields
+// of the response. For every field, the value is taken from the first
+// candidate whose value is not restricted or, if every value is restricted,
+// from the least restricted one. If provenance is true, the response includes
+// a "provenance" object with the target which supplied each value indexed by
+// its JSON path
+func MergeResponses(candidates []*APIGatorResponse, ec *EvaluationContext, provenance bool) (*APIGatorResponse, error) {
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no responses to merge")
+	}
+
+	// Parsing the dataSet of every candidate
+	var primaryBody map[string]interface{}
+	nodes := make([]mergeNode, 0, len(candidates))
+	for i, c := range candidates {
+		bodyBytes, err := c.bodyBytes()
+		if err != nil {
+			return nil, err
+		}
+
+		var body map[string]interface{}
+		if err := json.Unmarshal(bodyBytes, &body); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal response body from %s: %v", c.Name, err)
+		}
+		dataSetString, ok := body["dataSet"].(string)
+		if !ok {
+			return nil, fmt.Errorf("response from %s does not contain the 'dataSet' key", c.Name)
+		}
+		var dataSet interface{}
+		if err := json.Unmarshal([]byte(dataSetString), &dataSet); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal dataSet from %s: %v", c.Name, err)
+		}
+
+		if i == 0 {
+			primaryBody = body
+		}
+		nodes = append(nodes, mergeNode{value: dataSet, source: c.Name})
+	}
+
+	// Merging the dataSets and building the new response body
+	sources := make(map[string]string)
+	merged := mergeNodes(nodes, "$", 0, ec, sources)
+	mergedBytes, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	primaryBody["dataSet"] = string(mergedBytes)
+	if provenance {
+		primaryBody[provenanceKey] = sources
+	}
+	bodyBytes, err := json.Marshal(primaryBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// The merged response keeps the metadata of the preferred one
+	response := *candidates[0]
+	response.Response.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
+	response.Response.ContentLength = int64(len(bodyBytes))
+	response.Response.Header = response.Response.Header.Clone()
+	response.Response.Header.Del("Content-Length")
+	return &response, nil
+}
+
+// mergeNodes walks the same node of every candidate dataSet in parallel,
+// following the structure of the first one. Nested objects and arrays are
+// merged recursively, as well as the strings containing embedded JSON, which
+// are decoded the same way InspectLeaves does and encoded again once merged.
+// For every leaf the least restricted value is taken, preferring the first
+// candidates on ties. The source of every leaf is saved on 'sources' indexed
+// by its path. 'depth' is the nesting level of the node, as on walkLeaves
+func mergeNodes(nodes []mergeNode, path string, depth int, ec *EvaluationContext, sources map[string]string) interface{} {
+	switch primary := nodes[0].value.(type) {
+	// if nested JSON, merge every key defined on the first candidate
+	case map[string]interface{}:
+		merged := make(map[string]interface{}, len(primary))
+		for key := range primary {
+			var children []mergeNode
+			for _, n := range nodes {
+				if m, ok := n.value.(map[string]interface{}); ok {
+					if value, exists := m[key]; exists {
+						children = append(children, mergeNode{value: value, source: n.source})
+					}
+				}
+			}
+			merged[key] = mergeNodes(children, path+"."+key, depth+1, ec, sources)
+		}
+		return merged
+	// if array, merge the items on the same position
+	case []interface{}:
+		merged := make([]interface{}, len(primary))
+		for i := range primary {
+			var children []mergeNode
+			for _, n := range nodes {
+				if a, ok := n.value.([]interface{}); ok && i < len(a) {
+					children = append(children, mergeNode{value: a[i], source: n.source})
+				}
+			}
+			merged[i] = mergeNodes(children, path+"["+strconv.Itoa(i)+"]", depth+1, ec, sources)
+		}
+		return merged
+	// if string, merge the embedded JSON of the candidates having it, or
+	// take the least restricted value
+	case string:
+		if depth < ec.maxDepth() {
+			if embedded, ok := decodeEmbeddedJSON(primary); ok {
+				children := []mergeNode{{value: embedded, source: nodes[0].source}}
+				for _, n := range nodes[1:] {
+					if value, ok := n.value.(string); ok {
+						if embedded, ok := decodeEmbeddedJSON(value); ok {
+							children = append(children, mergeNode{value: embedded, source: n.source})
+						}
+					}
+				}
+				return encodeNode(mergeNodes(children, path, depth+1, ec, sources))
+			}
+		}
+		best := nodes[0]
+		bestRestriction := ec.Restriction(primary)
+		for _, n := range nodes[1:] {
+			if bestRestriction == 0 {
+				break
+			}
+			if value, ok := n.value.(string); ok {
+				if restriction := ec.Restriction(value); restriction < bestRestriction {
+					best, bestRestriction = n, restriction
+				}
+			}
+		}
+		sources[path] = best.source
+		return best.value
+	}
+
+	sources[path] = nodes[0].source
+	return nodes[0].value
+}
@@ -0,0 +1,81 @@
+package apigator
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMergeNodes(t *testing.T) {
+	tests := []struct {
+		name        string
+		candidates  []string
+		maxDepth    int
+		wantMerged  string
+		wantSources map[string]string
+	}{
+		{
+			name:        "least restricted leaf",
+			candidates:  []string{`{"a":"*****","b":"x"}`, `{"a":"y","b":"*****"}`},
+			wantMerged:  `{"a":"y","b":"x"}`,
+			wantSources: map[string]string{"$.a": "B", "$.b": "A"},
+		},
+		{
+			name:        "array items by position",
+			candidates:  []string{`{"l":["*****","x"]}`, `{"l":["y"]}`},
+			wantMerged:  `{"l":["y","x"]}`,
+			wantSources: map[string]string{"$.l[0]": "B", "$.l[1]": "A"},
+		},
+		{
+			name: "embedded JSON merged per field",
+			candidates: []string{
+				`{"p":"{\"name\":\"*****\",\"city\":\"Leeds\"}"}`,
+				`{"p":"{\"name\":\"Bob\",\"city\":\"*****\"}"}`,
+			},
+			wantMerged:  `{"p":"{\"city\":\"Leeds\",\"name\":\"Bob\"}"}`,
+			wantSources: map[string]string{"$.p.name": "B", "$.p.city": "A"},
+		},
+		{
+			name: "embedded JSON beyond the depth limit is a leaf",
+			candidates: []string{
+				`{"p":"{\"name\":\"*****\"}"}`,
+				`{"p":"{\"name\":\"Bob\"}"}`,
+			},
+			maxDepth:    1,
+			wantMerged:  `{"p":"{\"name\":\"Bob\"}"}`,
+			wantSources: map[string]string{"$.p": "B"},
+		},
+		{
+			name:        "plain string on the other candidate",
+			candidates:  []string{`{"p":"{\"name\":\"*****\"}"}`, `{"p":"Bob"}`},
+			wantMerged:  `{"p":"{\"name\":\"*****\"}"}`,
+			wantSources: map[string]string{"$.p.name": "A"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var nodes []mergeNode
+			for i, candidate := range tt.candidates {
+				var value interface{}
+				if err := json.Unmarshal([]byte(candidate), &value); err != nil {
+					t.Fatal(err)
+				}
+				nodes = append(nodes, mergeNode{value: value, source: string(rune('A' + i))})
+			}
+			ec := &EvaluationContext{RestrictedText: "*****", MaxDepth: tt.maxDepth}
+			sources := make(map[string]string)
+
+			merged, err := json.Marshal(mergeNodes(nodes, "$", 0, ec, sources))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(merged) != tt.wantMerged {
+				t.Errorf("merged = %s, want %s", merged, tt.wantMerged)
+			}
+			if !reflect.DeepEqual(sources, tt.wantSources) {
+				t.Errorf("sources = %v, want %v", sources, tt.wantSources)
+			}
+		})
+	}
+}
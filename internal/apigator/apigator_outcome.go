@@ -0,0 +1,185 @@
+package apigator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// OutcomePending means the target didn't finish before the decision
+	OutcomePending = "pending"
+	// OutcomeResponded means the target returned a response
+	OutcomeResponded = "responded"
+	// OutcomeFailed means the request to the target failed
+	OutcomeFailed = "failed"
+	// OutcomeCancelled means the request to the target was cancelled before
+	// it finished
+	OutcomeCancelled = "cancelled"
+	// OutcomeSkipped means the request was not forwarded to the target
+	OutcomeSkipped = "skipped"
+
+	// DiscardInvalidResponse means the response couldn't be read or decoded
+	DiscardInvalidResponse = "invalid_response"
+	// DiscardUnmodified means APIGator returned the dataSet as it was sent
+	DiscardUnmodified = "unmodified"
+	// DiscardSchemaMismatch means the dataSet doesn't comply with the schema
+	// of its manifest
+	DiscardSchemaMismatch = "schema_mismatch"
+	// DiscardNoScore means the evaluator didn't give a positive score
+	DiscardNoScore = "no_score"
+	// DiscardNotEvaluated means the response arrived after the selection
+	DiscardNotEvaluated = "not_evaluated"
+	// DiscardLowerScore means another response was selected
+	DiscardLowerScore = "lower_score"
+)
+
+// TargetOutcome describes what happened with the request forwarded to an
+// APIGatorTarget
+type TargetOutcome struct {
+	Target string
+	Status string
+	// HTTP status code returned by APIGator. 0 if there was no response
+	StatusCode int
+	Latency    time.Duration
+	// Score given to the response. Only meaningful if Evaluated is true
+	Score     float64
+	Evaluated bool
+	// Why the response was not selected. Set by Scored and Decided
+	DiscardReason string
+	// Error of a failed request
+	Error string
+	// SHA-256 of the response body, in hexadecimal
+	ResponseSHA256 string
+}
+
+// Outcomes collects the TargetOutcome of every APIGatorTarget a request is
+// forwarded to. It can be used concurrently by the forwarding threads and by
+// the evaluation of the responses
+type Outcomes struct {
+	mu       sync.Mutex
+	outcomes []TargetOutcome
+	index    map[string]int
+	// The selection is already done, so the new responses are not evaluated
+	decided bool
+}
+
+// NewOutcomes returns the Outcomes for a request forwarded to 'targets', all
+// of them pending
+func NewOutcomes(targets []*APIGatorTarget) *Outcomes {
+	o := &Outcomes{index: make(map[string]int, len(targets))}
+	for _, target := range targets {
+		if _, exists := o.index[target.Name]; exists {
+			continue
+		}
+		o.index[target.Name] = len(o.outcomes)
+		o.outcomes = append(o.outcomes, TargetOutcome{Target: target.Name, Status: OutcomePending})
+	}
+	return o
+}
+
+// Finished records the end of the request to a target. A nil error means the
+// response was handed over, and it's recorded by Responded. 'cancelled'
+// reports if the request was aborted by the router or the requester
+func (o *Outcomes) Finished(target string, err error, cancelled bool) {
+	if err == nil && !cancelled {
+		return
+	}
+	o.update(target, func(outcome *TargetOutcome) {
+		if outcome.Status == OutcomeResponded {
+			return
+		}
+		outcome.Status = OutcomeFailed
+		if cancelled {
+			outcome.Status = OutcomeCancelled
+		}
+		if err != nil {
+			outcome.Error = err.Error()
+		}
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			outcome.StatusCode = statusErr.StatusCode
+		}
+	})
+}
+
+// Skipped records a target the request was not forwarded to, and why
+func (o *Outcomes) Skipped(target string, reason string) {
+	o.update(target, func(outcome *TargetOutcome) {
+		outcome.Status = OutcomeSkipped
+		outcome.Error = reason
+	})
+}
+
+// Responded records the response returned by a target. Responses arriving
+// after Decided are recorded as not evaluated
+func (o *Outcomes) Responded(r *APIGatorResponse) {
+	body, _ := r.bodyBytes()
+	digest := sha256.Sum256(body)
+	o.update(r.Name, func(outcome *TargetOutcome) {
+		outcome.Status = OutcomeResponded
+		outcome.StatusCode = r.Response.StatusCode
+		outcome.Latency = r.Latency
+		outcome.Error = ""
+		outcome.ResponseSHA256 = hex.EncodeToString(digest[:])
+		if o.decided {
+			outcome.DiscardReason = DiscardNotEvaluated
+		}
+	})
+}
+
+// Scored records the score given to the response of a target, and the
+// reason if it was discarded by the evaluation
+func (o *Outcomes) Scored(target string, score float64, discardReason string) {
+	o.update(target, func(outcome *TargetOutcome) {
+		outcome.Score = score
+		outcome.Evaluated = true
+		outcome.DiscardReason = discardReason
+	})
+}
+
+// Decided records the discard reason of the responses not used after the
+// selection. 'used' are the targets whose responses were returned (several
+// ones on merge mode)
+func (o *Outcomes) Decided(used ...string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.decided = true
+	selected := make(map[string]bool, len(used))
+	for _, target := range used {
+		selected[target] = true
+	}
+	for i := range o.outcomes {
+		outcome := &o.outcomes[i]
+		if outcome.Status != OutcomeResponded || outcome.DiscardReason != "" || selected[outcome.Target] {
+			continue
+		}
+		if !outcome.Evaluated {
+			outcome.DiscardReason = DiscardNotEvaluated
+		} else {
+			outcome.DiscardReason = DiscardLowerScore
+		}
+	}
+}
+
+// List returns a copy of the outcomes in the order of the targets
+func (o *Outcomes) List() []TargetOutcome {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return append([]TargetOutcome(nil), o.outcomes...)
+}
+
+// update modifies the outcome of a target holding the lock. Unknown targets
+// are ignored
+func (o *Outcomes) update(target string, modify func(outcome *TargetOutcome)) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if i, exists := o.index[target]; exists {
+		modify(&o.outcomes[i])
+	}
+}
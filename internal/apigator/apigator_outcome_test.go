@@ -0,0 +1,143 @@
+package apigator
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testResponse returns the response of a target with the status code and body
+func testResponse(target string, statusCode int, body string) *APIGatorResponse {
+	return &APIGatorResponse{
+		Name:     target,
+		Latency:  time.Second,
+		Response: http.Response{StatusCode: statusCode, Body: ioutil.NopCloser(bytes.NewBufferString(body))},
+	}
+}
+
+func TestOutcomes(t *testing.T) {
+	const bodyHash = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae" // "foo"
+	responded := func(target string) TargetOutcome {
+		return TargetOutcome{Target: target, Status: OutcomeResponded, StatusCode: 200, Latency: time.Second, ResponseSHA256: bodyHash}
+	}
+
+	tests := []struct {
+		name   string
+		events func(o *Outcomes)
+		want   []TargetOutcome
+	}{
+		{
+			name:   "pending",
+			events: func(o *Outcomes) {},
+			want: []TargetOutcome{
+				{Target: "A", Status: OutcomePending},
+				{Target: "B", Status: OutcomePending},
+			},
+		},
+		{
+			name: "best response selected",
+			events: func(o *Outcomes) {
+				o.Responded(testResponse("A", 200, "foo"))
+				o.Scored("A", 0.8, "")
+				o.Responded(testResponse("B", 200, "foo"))
+				o.Scored("B", 0.5, "")
+				o.Decided("A")
+			},
+			want: func() []TargetOutcome {
+				a, b := responded("A"), responded("B")
+				a.Score, a.Evaluated = 0.8, true
+				b.Score, b.Evaluated, b.DiscardReason = 0.5, true, DiscardLowerScore
+				return []TargetOutcome{a, b}
+			}(),
+		},
+		{
+			name: "discarded by the evaluation",
+			events: func(o *Outcomes) {
+				o.Responded(testResponse("A", 200, "foo"))
+				o.Scored("A", -1, DiscardUnmodified)
+				o.Decided()
+			},
+			want: func() []TargetOutcome {
+				a := responded("A")
+				a.Score, a.Evaluated, a.DiscardReason = -1, true, DiscardUnmodified
+				return []TargetOutcome{a, {Target: "B", Status: OutcomePending}}
+			}(),
+		},
+		{
+			name: "response after the decision",
+			events: func(o *Outcomes) {
+				o.Responded(testResponse("A", 200, "foo"))
+				o.Scored("A", 1, "")
+				o.Decided("A")
+				o.Responded(testResponse("B", 200, "foo"))
+			},
+			want: func() []TargetOutcome {
+				a, b := responded("A"), responded("B")
+				a.Score, a.Evaluated = 1, true
+				b.DiscardReason = DiscardNotEvaluated
+				return []TargetOutcome{a, b}
+			}(),
+		},
+		{
+			name: "merged responses",
+			events: func(o *Outcomes) {
+				o.Responded(testResponse("A", 200, "foo"))
+				o.Scored("A", 0.8, "")
+				o.Responded(testResponse("B", 200, "foo"))
+				o.Scored("B", 0.5, "")
+				o.Decided("A", "B")
+			},
+			want: func() []TargetOutcome {
+				a, b := responded("A"), responded("B")
+				a.Score, a.Evaluated = 0.8, true
+				b.Score, b.Evaluated = 0.5, true
+				return []TargetOutcome{a, b}
+			}(),
+		},
+		{
+			name: "failed and cancelled",
+			events: func(o *Outcomes) {
+				o.Finished("A", &StatusError{StatusCode: 503, Body: "down"}, false)
+				o.Finished("B", errors.New("context canceled"), true)
+			},
+			want: []TargetOutcome{
+				{Target: "A", Status: OutcomeFailed, StatusCode: 503, Error: "Request failed. Response Code: 503. HTTP response body: down"},
+				{Target: "B", Status: OutcomeCancelled, Error: "context canceled"},
+			},
+		},
+		{
+			name: "finished after responding",
+			events: func(o *Outcomes) {
+				o.Responded(testResponse("A", 200, "foo"))
+				o.Finished("A", nil, true)
+				o.Finished("B", nil, false)
+			},
+			want: []TargetOutcome{responded("A"), {Target: "B", Status: OutcomePending}},
+		},
+		{
+			name: "skipped and unknown targets",
+			events: func(o *Outcomes) {
+				o.Skipped("A", "circuit breaker open")
+				o.Skipped("C", "unknown")
+			},
+			want: []TargetOutcome{
+				{Target: "A", Status: OutcomeSkipped, Error: "circuit breaker open"},
+				{Target: "B", Status: OutcomePending},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := NewOutcomes([]*APIGatorTarget{{Name: "A"}, {Name: "B"}, {Name: "A"}})
+			tt.events(o)
+			if got := o.List(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("List =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
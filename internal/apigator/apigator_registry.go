@@ -0,0 +1,117 @@
+package apigator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// EvaluatorParamsLoader fills the typed parameters of the evaluator registered
+// as 'name' from its own configuration section. 'params' is always a pointer
+// to the parameters struct given on RegisterEvaluator, already initialized with
+// the default values
+type EvaluatorParamsLoader func(name string, params interface{}) error
+
+// evaluatorFactory builds a new instance of a registered evaluator, loading its
+// parameters with the given loader
+type evaluatorFactory func(load EvaluatorParamsLoader) (APIGatorResponseEvaluator, error)
+
+// registeredEvaluator is an entry of the evaluators registry
+type registeredEvaluator struct {
+	factory evaluatorFactory
+	// returns a pointer to a copy of the default parameters
+	params func() interface{}
+}
+
+var (
+	// evaluatorsMu protects the evaluators registry
+	evaluatorsMu sync.RWMutex
+
+	// evaluators is the registry of the available evaluators indexed by the
+	// name used on the 'score_function' parameter
+	evaluators = make(map[string]registeredEvaluator)
+)
+
+// RegisterEvaluator makes an evaluator available for the router under the
+// given name. 'defaults' are the typed parameters of the evaluator with their
+// default values; the keys defined on its configuration section override them
+// before calling 'build'. Evaluators without parameters can use 'struct{}'.
+// It's meant to be called from 'init' functions, and it panics if the name is
+// already registered
+func RegisterEvaluator[P any](name string, defaults P, build func(params P) (APIGatorResponseEvaluator, error)) {
+	registerEvaluator(name, defaults, func(params P, _ EvaluatorParamsLoader) (APIGatorResponseEvaluator, error) {
+		return build(params)
+	})
+}
+
+// registerEvaluator is the same as RegisterEvaluator, but it also hands the
+// parameters loader over to 'build', so evaluators made of other evaluators
+// can build them with their own parameters
+func registerEvaluator[P any](name string, defaults P, build func(params P, load EvaluatorParamsLoader) (APIGatorResponseEvaluator, error)) {
+	evaluatorsMu.Lock()
+	defer evaluatorsMu.Unlock()
+
+	if _, exists := evaluators[name]; exists {
+		panic(fmt.Sprintf("evaluator %q registered twice", name))
+	}
+
+	evaluators[name] = registeredEvaluator{
+		factory: func(load EvaluatorParamsLoader) (APIGatorResponseEvaluator, error) {
+			params := defaults
+			if err := load(name, &params); err != nil {
+				return nil, err
+			}
+			return build(params, load)
+		},
+		params: func() interface{} {
+			params := defaults
+			return &params
+		},
+	}
+}
+
+// NewEvaluator builds the evaluator registered under 'name'. An error is
+// returned if there is no evaluator with that name or if its parameters are
+// not valid
+func NewEvaluator(name string, load EvaluatorParamsLoader) (APIGatorResponseEvaluator, error) {
+	evaluatorsMu.RLock()
+	registered, exists := evaluators[name]
+	evaluatorsMu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("unknown evaluator %q. Available evaluators: %s", name, strings.Join(EvaluatorNames(), ", "))
+	}
+
+	evaluator, err := registered.factory(load)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build evaluator %q: %v", name, err)
+	}
+	return evaluator, nil
+}
+
+// EvaluatorParams returns a pointer to the default parameters of the
+// evaluator registered under 'name', or false if there is no such evaluator
+func EvaluatorParams(name string) (interface{}, bool) {
+	evaluatorsMu.RLock()
+	defer evaluatorsMu.RUnlock()
+
+	registered, exists := evaluators[name]
+	if !exists {
+		return nil, false
+	}
+	return registered.params(), true
+}
+
+// EvaluatorNames returns the sorted list of registered evaluators
+func EvaluatorNames() []string {
+	evaluatorsMu.RLock()
+	defer evaluatorsMu.RUnlock()
+
+	names := make([]string, 0, len(evaluators))
+	for name := range evaluators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,128 @@
+package apigator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	ini "gopkg.in/ini.v1"
+)
+
+// testConstantParams are the parameters of the "test_constant" evaluator
+type testConstantParams struct {
+	Score float64 `ini:"score"`
+}
+
+// Registering the evaluators used by the tests: "test_constant" scores every
+// response with its 'score' parameter, and "test_disqualify" disqualifies
+// every response
+func init() {
+	RegisterEvaluator("test_constant", testConstantParams{Score: bestResponseScore}, func(params testConstantParams) (APIGatorResponseEvaluator, error) {
+		if params.Score < worstResponseScore || params.Score > bestResponseScore {
+			return nil, fmt.Errorf("score must be between 0.0 and 1.0")
+		}
+		return func(map[string]interface{}, *zap.Logger, *EvaluationContext) float64 {
+			return params.Score
+		}, nil
+	})
+	RegisterEvaluator("test_disqualify", struct{}{}, func(struct{}) (APIGatorResponseEvaluator, error) {
+		return func(map[string]interface{}, *zap.Logger, *EvaluationContext) float64 {
+			return -1
+		}, nil
+	})
+}
+
+// iniParamsLoader returns a loader reading the parameters of the evaluators
+// from the "evaluator.<name>" sections of an INI 'config', like the router does
+func iniParamsLoader(t *testing.T, config string) EvaluatorParamsLoader {
+	t.Helper()
+
+	cfg, err := ini.Load([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(name string, params interface{}) error {
+		section, err := cfg.GetSection("evaluator." + name)
+		if err != nil {
+			return nil
+		}
+		return section.MapTo(params)
+	}
+}
+
+func TestNewEvaluator(t *testing.T) {
+	tests := []struct {
+		name      string
+		evaluator string
+		config    string
+		want      float64
+		wantErr   string
+	}{
+		{name: "default parameters", evaluator: "test_constant", want: 1},
+		{name: "parameters of other evaluators", evaluator: "test_constant", config: "[evaluator.percentage]\nscore = 0.5\n", want: 1},
+		{name: "INI parameters", evaluator: "test_constant", config: "[evaluator.test_constant]\nscore = 0.25\n", want: 0.25},
+		{name: "parameters rejected", evaluator: "test_constant", config: "[evaluator.test_constant]\nscore = 2\n", wantErr: `failed to build evaluator "test_constant": score must be between 0.0 and 1.0`},
+		{name: "without parameters", evaluator: "test_disqualify", want: -1},
+		{name: "unknown evaluator", evaluator: "unknown", wantErr: `unknown evaluator "unknown". Available evaluators: basic,`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluator, err := NewEvaluator(tt.evaluator, iniParamsLoader(t, tt.config))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewEvaluator() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := evaluator(nil, zap.NewNop(), &EvaluationContext{}); got != tt.want {
+				t.Errorf("score = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewEvaluatorLoader(t *testing.T) {
+	// Loading the parameters of an instance doesn't change the defaults of
+	// the next ones
+	load := func(name string, params interface{}) error {
+		params.(*testConstantParams).Score = 0.5
+		return nil
+	}
+	if _, err := NewEvaluator("test_constant", load); err != nil {
+		t.Fatal(err)
+	}
+	evaluator, err := NewEvaluator("test_constant", iniParamsLoader(t, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := evaluator(nil, zap.NewNop(), &EvaluationContext{}); got != 1 {
+		t.Errorf("score = %v, want the default 1", got)
+	}
+
+	failing := func(string, interface{}) error { return fmt.Errorf("broken loader") }
+	if _, err := NewEvaluator("test_constant", failing); err == nil || !strings.Contains(err.Error(), "broken loader") {
+		t.Errorf("NewEvaluator() error = %v, want the loader error", err)
+	}
+}
+
+func TestRegisterEvaluator(t *testing.T) {
+	names := EvaluatorNames()
+	if !sort.StringsAreSorted(names) || !strings.Contains(strings.Join(names, ","), "test_constant,test_disqualify") {
+		t.Errorf("EvaluatorNames() = %v, want every evaluator sorted", names)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("RegisterEvaluator() didn't panic registering a name twice")
+		}
+	}()
+	RegisterEvaluator("basic", struct{}{}, func(struct{}) (APIGatorResponseEvaluator, error) {
+		return BasicEvaluator, nil
+	})
+}
@@ -0,0 +1,102 @@
+package apigator
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// Registering the required fields evaluator
+func init() {
+	RegisterEvaluator("required_fields", RequiredFieldsEvaluatorParams{}, NewRequiredFieldsEvaluator)
+}
+
+// RequiredFieldsEvaluatorParams defines the parameters of the
+// "required_fields" evaluator, configured on the "[evaluator.required_fields]"
+// section
+type RequiredFieldsEvaluatorParams struct {
+	// JSONPath-like selectors of the fields to evaluate
+	Fields []string `ini:"fields" delim:","`
+	// If true, the requester can replace the configured fields with the
+	// selectors sent on the request headers
+	AllowHeader bool `ini:"allow_header"`
+}
+
+// NewRequiredFieldsEvaluator returns an evaluator which scores the share of
+// the required fields that are not restricted on the response dataSet.
+// Selectors matching nothing count as restricted fields. If there are no
+// required fields, every field of the dataSet is evaluated
+func NewRequiredFieldsEvaluator(params RequiredFieldsEvaluatorParams) (APIGatorResponseEvaluator, error) {
+	if len(params.Fields) == 0 && !params.AllowHeader {
+		return nil, fmt.Errorf("at least one field is required when allow_header is disabled")
+	}
+
+	fields, err := compileJSONPaths(params.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(data map[string]interface{}, logger *zap.Logger, ec *EvaluationContext) float64 {
+		// Fields requested by the requester replace the configured ones
+		selectors := fields
+		if params.AllowHeader && len(ec.RequiredFields) > 0 {
+			requested, err := compileJSONPaths(ec.RequiredFields)
+			if err != nil {
+				logger.Warn("Ignoring required fields from request", zap.Error(err))
+			} else {
+				selectors = requested
+			}
+		}
+
+		if len(selectors) == 0 {
+			logger.Debug("No required fields defined. Evaluating every field")
+			return PercentEvaluator(data, logger, ec)
+		}
+
+		var dataSet interface{}
+		dataSetString, _ := data["dataSet"].(string)
+		if err := json.Unmarshal([]byte(dataSetString), &dataSet); err != nil {
+			logger.Error("Failed to Unmarshal response body", zap.Error(err))
+			return -1.0
+		}
+
+		var restricted float64
+		var total int
+		for _, selector := range selectors {
+			matches := selector.Select(dataSet)
+			if len(matches) == 0 {
+				logger.Debug("Required field not found on response", zap.String("field", selector.String()))
+				restricted++
+				total++
+				continue
+			}
+			for _, match := range matches {
+				r, t := InspectLeaves(match, ec).Totals()
+				restricted += r
+				total += t
+			}
+		}
+		if total == 0 {
+			return worstResponseScore
+		}
+
+		return bestResponseScore - restricted/float64(total)
+	}, nil
+}
+
+// compileJSONPaths compiles a list of JSONPath-like selectors
+func compileJSONPaths(exprs []string) ([]*JSONPath, error) {
+	var paths []*JSONPath
+	for _, expr := range exprs {
+		if strings.TrimSpace(expr) == "" {
+			continue
+		}
+		path, err := CompileJSONPath(expr)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
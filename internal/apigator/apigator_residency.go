@@ -0,0 +1,140 @@
+package apigator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ResidencyRule restricts where the payloads owned by a country (or by any
+// country of a region) can be forwarded: only to the targets located on one
+// of the allowed countries or regions
+type ResidencyRule struct {
+	// Country code or region of the dataOwningCountryCode
+	Owner string
+	// Country codes and regions of the allowed target locations
+	Allowed []string
+}
+
+// String returns the rule as it's configured, for the logs
+func (r ResidencyRule) String() string {
+	return r.Owner + " = " + strings.Join(r.Allowed, ",")
+}
+
+// ResidencyPolicy is the data-residency guardrail. It's defined by the rules
+// of the [residency] section, whose keys are dataOwningCountryCodes or
+// regions and whose values are the countries and regions where the targets
+// receiving their payloads must be located. Regions are defined on the
+// [regions] section as lists of country codes. Every rule applying to a
+// payload must be satisfied, and a target without 'location' never
+// satisfies a rule
+type ResidencyPolicy struct {
+	// Country codes of every region, by region name
+	regions map[string]map[string]bool
+	rules   []ResidencyRule
+}
+
+// NewResidencyPolicy returns the ResidencyPolicy with the 'rules' and the
+// 'regions' they use, both given as comma separated lists by key
+func NewResidencyPolicy(regions map[string]string, rules map[string]string) (*ResidencyPolicy, error) {
+	p := &ResidencyPolicy{regions: make(map[string]map[string]bool, len(regions))}
+	for name, countries := range regions {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		p.regions[name] = make(map[string]bool)
+		for _, country := range splitCodes(countries) {
+			if !isCountryCode(country) {
+				return nil, fmt.Errorf("invalid country code %q on region %q", country, name)
+			}
+			p.regions[name][country] = true
+		}
+	}
+
+	for owner, allowed := range rules {
+		rule := ResidencyRule{Owner: strings.ToUpper(strings.TrimSpace(owner)), Allowed: splitCodes(allowed)}
+		if !p.known(rule.Owner) {
+			return nil, fmt.Errorf("unknown country code or region %q on residency rule", rule.Owner)
+		}
+		if len(rule.Allowed) == 0 {
+			return nil, fmt.Errorf("residency rule %q allows no location", rule.Owner)
+		}
+		for _, location := range rule.Allowed {
+			if !p.known(location) {
+				return nil, fmt.Errorf("unknown country code or region %q on residency rule %q", location, rule.Owner)
+			}
+		}
+		p.rules = append(p.rules, rule)
+	}
+	// Rules are checked in the same order on every request
+	sort.Slice(p.rules, func(i, j int) bool { return p.rules[i].Owner < p.rules[j].Owner })
+	return p, nil
+}
+
+// Violation returns the first rule the target breaks by receiving a payload
+// owned by 'dataOwningCountryCode', or nil if it's allowed. A nil
+// ResidencyPolicy allows everything. Payloads without owner must be rejected
+// before, as no rule applies to them
+func (p *ResidencyPolicy) Violation(target *APIGatorTarget, dataOwningCountryCode string) *ResidencyRule {
+	if p == nil {
+		return nil
+	}
+	owner := strings.ToUpper(strings.TrimSpace(dataOwningCountryCode))
+	location := strings.ToUpper(strings.TrimSpace(target.Location))
+	for i := range p.rules {
+		rule := &p.rules[i]
+		if !p.includes(rule.Owner, owner) {
+			continue
+		}
+		allowed := false
+		for _, place := range rule.Allowed {
+			if p.includes(place, location) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return rule
+		}
+	}
+	return nil
+}
+
+// includes reports if the country is 'place' or belongs to the region 'place'
+func (p *ResidencyPolicy) includes(place string, country string) bool {
+	if country == "" {
+		return false
+	}
+	if region, exists := p.regions[place]; exists {
+		return region[country]
+	}
+	return place == country
+}
+
+// known reports if 'place' is a region or a country code
+func (p *ResidencyPolicy) known(place string) bool {
+	_, isRegion := p.regions[place]
+	return isRegion || isCountryCode(place)
+}
+
+// splitCodes splits a comma separated list of codes, in upper case
+func splitCodes(list string) []string {
+	var codes []string
+	for _, code := range strings.Split(list, ",") {
+		if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// isCountryCode reports if 'code' looks like an ISO 3166-1 alpha-2 code
+func isCountryCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
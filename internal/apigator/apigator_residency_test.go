@@ -0,0 +1,76 @@
+package apigator
+
+import (
+	"testing"
+)
+
+func TestNewResidencyPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		regions map[string]string
+		rules   map[string]string
+		wantErr bool
+	}{
+		{name: "no rules"},
+		{name: "countries", rules: map[string]string{"GB": "GB,IE"}},
+		{name: "regions", regions: map[string]string{"eu": "de, fr"}, rules: map[string]string{"EU": "eu", "GB": "GB,EU"}},
+		{name: "invalid country code on region", regions: map[string]string{"EU": "DE,FRA"}, wantErr: true},
+		{name: "unknown owner", rules: map[string]string{"EUROPE": "GB"}, wantErr: true},
+		{name: "unknown location", rules: map[string]string{"GB": "GB,EUROPE"}, wantErr: true},
+		{name: "no location allowed", rules: map[string]string{"GB": " , "}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewResidencyPolicy(tt.regions, tt.rules)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewResidencyPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResidencyViolation(t *testing.T) {
+	policy, err := NewResidencyPolicy(
+		map[string]string{"EU": "DE,FR,IE"},
+		map[string]string{"EU": "EU", "DE": "DE", "GB": "GB,EU"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		policy   *ResidencyPolicy
+		owner    string
+		location string
+		want     string // broken rule
+	}{
+		{name: "country allowed", policy: policy, owner: "GB", location: "GB"},
+		{name: "region allowed", policy: policy, owner: "GB", location: "IE"},
+		{name: "country denied", policy: policy, owner: "GB", location: "US", want: "GB = GB,EU"},
+		{name: "owner on a region", policy: policy, owner: "FR", location: "IE"},
+		{name: "owner on a region denied", policy: policy, owner: "FR", location: "GB", want: "EU = EU"},
+		{name: "every rule applies", policy: policy, owner: "DE", location: "FR", want: "DE = DE"},
+		{name: "every rule satisfied", policy: policy, owner: "DE", location: "DE"},
+		{name: "owner without rules", policy: policy, owner: "US", location: "JP"},
+		{name: "ignoring case and spaces", policy: policy, owner: " gb ", location: "ie"},
+		{name: "target without location", policy: policy, owner: "GB", want: "GB = GB,EU"},
+		{name: "missing owner", policy: policy, location: "US"},
+		{name: "no policy", owner: "GB", location: "US"},
+		{name: "no policy and missing owner", location: "US"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &APIGatorTarget{Name: "test", Location: tt.location}
+			var got string
+			if rule := tt.policy.Violation(target, tt.owner); rule != nil {
+				got = rule.String()
+			}
+			if got != tt.want {
+				t.Errorf("Violation(%q on %q) = %q, want %q", tt.owner, tt.location, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,10 +3,12 @@ package apigator
 import (
 	"bytes"
 	"encoding/json"
+	"exate-dora-router/internal/metrics"
 	"go.uber.org/zap"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 )
 
 // APIGatorResponse represents a response from an APIGator instance. It
@@ -15,34 +17,43 @@ import (
 type APIGatorResponse struct {
 	Response http.Response
 	Name     string
+	Priority float64
+	Latency  time.Duration
+	// Why the response was discarded by EvaluateResponse. Empty if it was not
+	DiscardReason string
 }
 
 // EvaluateResponse evaulates a HTTP response is valid or not using the
-// funciton referenced by args and returns a boolean value with the result
-func (r *APIGatorResponse) EvaluateResponse(fp APIGatorResponseEvaluator, restrictedText string, original string, logger *zap.Logger) float64 {
+// funciton referenced by args and returns a boolean value with the result.
+// 'ec' holds the information of the incoming request; the information of the
+// response is added to it before calling the evaluator. If the response is
+// discarded, the reason is saved on DiscardReason
+func (r *APIGatorResponse) EvaluateResponse(fp APIGatorResponseEvaluator, ec EvaluationContext, original string, logger *zap.Logger) float64 {
 	var responseData map[string]interface{}
 
 	// Getting Response body as []bytes
-	respBodyBytes, err := ioutil.ReadAll(r.Response.Body)
+	respBodyBytes, err := r.bodyBytes()
 	if err != nil {
+		r.DiscardReason = DiscardInvalidResponse
 		return -1.0
 	}
-	// Restore Request Body because it was supposed to be read just once
-	r.Response.Body = ioutil.NopCloser(bytes.NewBuffer(respBodyBytes))
 
 	// Unpackaging Response into JSON format
 	err = json.Unmarshal(respBodyBytes, &responseData)
 	if err != nil {
 		logger.Error("Failed to Unmarshal response body", zap.Error(err))
+		r.DiscardReason = DiscardInvalidResponse
 		return -1.0
 	}
 
 	// if the response is the same as the received request, it's discard
 	dataSet := responseData["dataSet"].(string)
 	if !isResponseModified(dataSet, original) {
+		r.DiscardReason = DiscardUnmodified
 		logger.Debug("Detected Response without any change. Discarding...",
 			zap.String("apigator", r.Name),
 		)
+		metrics.ObserveUnmodified(r.Name)
 		return -1.0
 	}
 
@@ -50,11 +61,38 @@ func (r *APIGatorResponse) EvaluateResponse(fp APIGatorResponseEvaluator, restri
 	// able to decrypt the payload or not, the evaluation is performed based on a
 	// specific method configured on the INI file
 	if r.Response.StatusCode == http.StatusOK {
-		return fp(responseData, logger, restrictedText)
+		ec.Target = r.Name
+		ec.Priority = r.Priority
+		ec.Latency = r.Latency
+		score := fp(responseData, logger, &ec)
+
+		// Responses not complying with the manifest schema are disqualified or penalised
+		if ec.Schema != nil && score >= 0 {
+			if score = ec.Schema.Apply(dataSet, score, r.Name, logger); score < 0 {
+				r.DiscardReason = DiscardSchemaMismatch
+				return score
+			}
+		}
+		if score <= 0 {
+			r.DiscardReason = DiscardNoScore
+		}
+		return score
 	}
+	r.DiscardReason = DiscardInvalidResponse
 	return -1.0
 }
 
+// bodyBytes returns the body of the HTTP response, restoring it afterwards
+// because it was supposed to be read just once
+func (r *APIGatorResponse) bodyBytes() ([]byte, error) {
+	respBodyBytes, err := ioutil.ReadAll(r.Response.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Response.Body = ioutil.NopCloser(bytes.NewBuffer(respBodyBytes))
+	return respBodyBytes, nil
+}
+
 // isResponseModified compares the responseBody and original strings and returns a
 // boolean value indicating if both parameters has the same value or not, which
 // indicates that the response was not modified by APIGator, and shouldn't be
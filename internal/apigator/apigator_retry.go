@@ -0,0 +1,142 @@
+package apigator
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultRetryMaxAttempts is the number of attempts of a request to
+	// APIGator when 'retry_max_attempts' is not configured
+	defaultRetryMaxAttempts = 5
+	// defaultRetryBackoff is the delay before the first retry when
+	// 'retry_backoff' is not configured
+	defaultRetryBackoff = 200 * time.Millisecond
+	// defaultRetryMaxBackoff is the longest delay between attempts when
+	// 'retry_max_backoff' is not configured
+	defaultRetryMaxBackoff = 5 * time.Second
+)
+
+// defaultRetryStatusCodes are the status codes retried when
+// 'retry_status_codes' is not configured
+var defaultRetryStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy defines which failed requests to APIGator are attempted again
+// and how long to wait before every retry. The delay grows exponentially from
+// 'backoff' up to 'maxBackoff', with a random jitter, unless APIGator asks
+// for a longer one with the Retry-After header. A 401 response is always
+// retried right away with a new Access Token
+type RetryPolicy struct {
+	maxAttempts     int
+	statusCodes     map[int]bool
+	transportErrors bool
+	backoff         time.Duration
+	maxBackoff      time.Duration
+	// Maximum time since the first attempt for starting a new one. 0 means
+	// no limit besides the number of attempts
+	budget time.Duration
+}
+
+// NewRetryPolicy returns the RetryPolicy of an APIGatorTarget. 0
+// 'maxAttempts', 'backoff' or 'maxBackoff' and empty 'statusCodes' mean their
+// default values. 'transportErrors' retries the requests failed without a
+// response (connection refused, timeout...)
+func NewRetryPolicy(maxAttempts int, statusCodes []int, transportErrors bool, backoff time.Duration, maxBackoff time.Duration, budget time.Duration) *RetryPolicy {
+	if maxAttempts == 0 {
+		maxAttempts = defaultRetryMaxAttempts
+	}
+	if len(statusCodes) == 0 {
+		statusCodes = defaultRetryStatusCodes
+	}
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+	if maxBackoff == 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+	p := &RetryPolicy{
+		maxAttempts:     maxAttempts,
+		statusCodes:     make(map[int]bool, len(statusCodes)),
+		transportErrors: transportErrors,
+		backoff:         backoff,
+		maxBackoff:      maxBackoff,
+		budget:          budget,
+	}
+	for _, code := range statusCodes {
+		p.statusCodes[code] = true
+	}
+	return p
+}
+
+// retryableStatus reports if a response with the status code can be retried
+func (p *RetryPolicy) retryableStatus(statusCode int) bool {
+	return p.statusCodes[statusCode]
+}
+
+// delay returns the time to wait before the retry number 'retry' (starting
+// at 1): the exponential backoff with equal jitter, or the Retry-After
+// requested by APIGator if it's longer
+func (p *RetryPolicy) delay(retry int, retryAfter time.Duration) time.Duration {
+	backoff := p.backoff
+	for i := 1; i < retry && backoff < p.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.maxBackoff {
+		backoff = p.maxBackoff
+	}
+	// Half of the backoff is fixed and the other half random, so the
+	// attempts of the concurrent requests don't arrive together
+	delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	return delay
+}
+
+// allows reports if a new attempt can start after waiting 'delay', given the
+// number of attempts done and the time of the first one
+func (p *RetryPolicy) allows(attempts int, start time.Time, delay time.Duration) bool {
+	if attempts >= p.maxAttempts {
+		return false
+	}
+	return p.budget <= 0 || time.Since(start)+delay <= p.budget
+}
+
+// wait sleeps for 'delay' unless the context is done before
+func wait(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryAfter returns the delay requested by the Retry-After header of a
+// response, given in seconds or as an HTTP date. 0 if there is none
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
@@ -0,0 +1,153 @@
+package apigator
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *RetryPolicy
+		want   *RetryPolicy
+	}{
+		{
+			name:   "defaults",
+			policy: NewRetryPolicy(0, nil, false, 0, 0, 0),
+			want: &RetryPolicy{
+				maxAttempts: defaultRetryMaxAttempts,
+				statusCodes: map[int]bool{429: true, 502: true, 503: true, 504: true},
+				backoff:     defaultRetryBackoff,
+				maxBackoff:  defaultRetryMaxBackoff,
+			},
+		},
+		{
+			name:   "configured",
+			policy: NewRetryPolicy(2, []int{500}, true, time.Second, time.Minute, time.Hour),
+			want: &RetryPolicy{
+				maxAttempts:     2,
+				statusCodes:     map[int]bool{500: true},
+				transportErrors: true,
+				backoff:         time.Second,
+				maxBackoff:      time.Minute,
+				budget:          time.Hour,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.policy, tt.want) {
+				t.Errorf("NewRetryPolicy = %+v, want %+v", tt.policy, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyRetryableStatus(t *testing.T) {
+	p := NewRetryPolicy(0, nil, false, 0, 0, 0)
+	for code, want := range map[int]bool{429: true, 502: true, 503: true, 504: true, 400: false, 500: false, 501: false} {
+		if got := p.retryableStatus(code); got != want {
+			t.Errorf("retryableStatus(%d) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := NewRetryPolicy(0, nil, false, 100*time.Millisecond, time.Second, 0)
+
+	tests := []struct {
+		name       string
+		retry      int
+		retryAfter time.Duration
+		min, max   time.Duration
+	}{
+		{name: "first retry", retry: 1, min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		{name: "second retry", retry: 2, min: 100 * time.Millisecond, max: 200 * time.Millisecond},
+		{name: "fourth retry", retry: 4, min: 400 * time.Millisecond, max: 800 * time.Millisecond},
+		{name: "capped at the max backoff", retry: 10, min: 500 * time.Millisecond, max: time.Second},
+		{name: "longer Retry-After", retry: 1, retryAfter: 3 * time.Second, min: 3 * time.Second, max: 3 * time.Second},
+		{name: "shorter Retry-After", retry: 2, retryAfter: time.Millisecond, min: 100 * time.Millisecond, max: 200 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The jitter is random, so every case is checked several times
+			for i := 0; i < 100; i++ {
+				if got := p.delay(tt.retry, tt.retryAfter); got < tt.min || got > tt.max {
+					t.Fatalf("delay(%d, %v) = %v, want between %v and %v", tt.retry, tt.retryAfter, got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryPolicyAllows(t *testing.T) {
+	tests := []struct {
+		name     string
+		budget   time.Duration
+		attempts int
+		elapsed  time.Duration
+		delay    time.Duration
+		want     bool
+	}{
+		{name: "attempts left", attempts: 2, want: true},
+		{name: "attempts exhausted", attempts: 3, want: false},
+		{name: "no budget", attempts: 1, elapsed: time.Hour, delay: time.Hour, want: true},
+		{name: "within the budget", budget: time.Minute, attempts: 1, elapsed: 10 * time.Second, delay: 10 * time.Second, want: true},
+		{name: "delay beyond the budget", budget: time.Minute, attempts: 1, elapsed: 10 * time.Second, delay: time.Minute, want: false},
+		{name: "budget spent", budget: time.Minute, attempts: 1, elapsed: 2 * time.Minute, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewRetryPolicy(3, nil, false, 0, 0, tt.budget)
+			if got := p.allows(tt.attempts, time.Now().Add(-tt.elapsed), tt.delay); got != tt.want {
+				t.Errorf("allows = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		min, max time.Duration
+	}{
+		{name: "missing"},
+		{name: "seconds", value: "7", min: 7 * time.Second, max: 7 * time.Second},
+		{name: "zero seconds", value: "0"},
+		{name: "negative seconds", value: "-3"},
+		{name: "invalid", value: "soon"},
+		{name: "HTTP date", value: time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), min: 58 * time.Second, max: time.Minute},
+		{name: "past HTTP date", value: time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: make(http.Header)}
+			if tt.value != "" {
+				resp.Header.Set("Retry-After", tt.value)
+			}
+			if got := retryAfter(resp); got < tt.min || got > tt.max {
+				t.Errorf("retryAfter(%q) = %v, want between %v and %v", tt.value, got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestWait(t *testing.T) {
+	if err := wait(context.Background(), time.Millisecond); err != nil {
+		t.Errorf("wait = %v, want nil", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := wait(ctx, time.Hour); err != context.Canceled {
+		t.Errorf("wait on a cancelled context = %v, want %v", err, context.Canceled)
+	}
+}
@@ -3,6 +3,32 @@
 // instances
 package apigator
 
+import (
+	"exate-dora-router/internal/audit"
+	"exate-dora-router/internal/tracing"
+	"time"
+)
+
+const (
+	// SelectionModeBest waits for every APIGatorTarget and returns the
+	// response with the highest score
+	SelectionModeBest = "best"
+	// SelectionModeRace returns the first response reaching the RaceScore and
+	// cancels the requests still in-flight
+	SelectionModeRace = "race"
+	// SelectionModeMerge waits for every APIGatorTarget and merges the valid
+	// responses, taking for every field the first value not restricted
+	SelectionModeMerge = "merge"
+
+	// DefaultRaceScore is the score a response must reach on race mode when
+	// no 'race_score' is configured
+	DefaultRaceScore = bestResponseScore
+
+	// DefaultMinHealthyTargets is the number of healthy targets needed for
+	// the router to be ready when no 'min_healthy_targets' is configured
+	DefaultMinHealthyTargets = 1
+)
+
 // APIGatorRouter defines the global configuration object for this Dora Router
 // software. It also includes the list of APIGators to forward the request
 type APIGatorRouter struct {
@@ -12,4 +38,140 @@ type APIGatorRouter struct {
 	APIGatorTargets []*APIGatorTarget
 	ScoreFuncName   string `ini:"score_function"`
 	ScoreFunc       APIGatorResponseEvaluator
+	SelectionMode   string  `ini:"selection_mode"`
+	RaceScore       float64 `ini:"race_score"`
+	MergeProvenance bool    `ini:"merge_provenance"`
+	SchemaMode      string  `ini:"schema_mode"`
+	SchemaPenalty   float64 `ini:"schema_penalty"`
+	Schemas         *SchemaRegistry
+	Masking         *MaskMatcher
+	Residency       *ResidencyPolicy
+	MaxDepth        int `ini:"max_depth"`
+	// Seconds between checks of the configuration file for reloading it. 0
+	// means the default interval, and a negative value disables the checks
+	ReloadInterval time.Duration `ini:"reload_interval"`
+	// Adds the X-Dora-Selected-Target, X-Dora-Score and X-Dora-Candidates
+	// headers to the responses
+	DecisionHeaders bool `ini:"decision_headers"`
+	// Allows the requesters to get the explanation of the decision on the
+	// response, sending the X-Dora-Explain header
+	AllowExplain bool `ini:"allow_explain"`
+	// Minimum number of healthy targets for the router to be ready
+	MinHealthyTargets int `ini:"min_healthy_targets"`
+	// What to do with a request whose jurisdiction and manifest are not
+	// served by any target: "broadcast" or "reject"
+	RoutingFallback string `ini:"routing_fallback"`
+	// Overall deadline in seconds for every incoming request. When reached,
+	// the responses already received are evaluated. 0 means no deadline
+	Timeout time.Duration `ini:"timeout"`
+	// Exporter of the tracing spans, configured on the [tracing] section
+	Tracing tracing.Config `ini:"-"`
+	// Audit file of the routing decisions, configured on the [audit] section
+	Audit audit.Config `ini:"-"`
+}
+
+// InheritTokens makes the APIGatorTargets reuse the TokenManagers of the
+// targets of a previous configuration with the same credentials, so their
+// tokens are kept across configuration reloads. It returns the TokenManagers
+// of the previous configuration which were not inherited, that should be
+// closed once the previous configuration is not used anymore
+func (r *APIGatorRouter) InheritTokens(previous *APIGatorRouter) []*TokenManager {
+	inherited := make(map[*TokenManager]bool)
+	for _, target := range r.APIGatorTargets {
+		for _, old := range previous.APIGatorTargets {
+			if inherited[old.Tokens] || !target.sameCredentials(old) {
+				continue
+			}
+			target.Tokens.Close()
+			target.Tokens = old.Tokens
+			target.Tokens.rebind(target)
+			inherited[old.Tokens] = true
+			break
+		}
+	}
+
+	var unused []*TokenManager
+	for _, old := range previous.APIGatorTargets {
+		if !inherited[old.Tokens] {
+			unused = append(unused, old.Tokens)
+		}
+	}
+	return unused
+}
+
+// InheritBreakers makes the APIGatorTargets reuse the CircuitBreakers of the
+// targets of a previous configuration with the same name, host and breaker
+// settings, so an open breaker stays open across configuration reloads
+func (r *APIGatorRouter) InheritBreakers(previous *APIGatorRouter) {
+	for _, target := range r.APIGatorTargets {
+		for _, old := range previous.APIGatorTargets {
+			if target.Name == old.Name && target.Host == old.Host && target.Port == old.Port && target.Breaker.sameSettings(old.Breaker) {
+				target.Breaker = old.Breaker
+				break
+			}
+		}
+	}
+}
+
+// PublishBreakers publishes the state of the CircuitBreakers of every
+// APIGatorTarget on the metrics. It must be called once the configuration is
+// in use, so a configuration which is built but rejected doesn't overwrite
+// the state of the breakers running
+func (r *APIGatorRouter) PublishBreakers() {
+	for _, target := range r.APIGatorTargets {
+		target.Breaker.publish()
+	}
+}
+
+// InheritLatencies makes the APIGatorTargets keep the recent latencies of the
+// hosts they share with the targets of a previous configuration with the
+// same name, so hedging doesn't stop after a configuration reload
+func (r *APIGatorRouter) InheritLatencies(previous *APIGatorRouter) {
+	for _, target := range r.APIGatorTargets {
+		for _, old := range previous.APIGatorTargets {
+			if target.Name == old.Name && target.Port == old.Port {
+				for host, tracker := range target.Latencies {
+					if oldTracker, ok := old.Latencies[host]; ok {
+						tracker.inherit(oldTracker)
+					}
+				}
+				break
+			}
+		}
+	}
+}
+
+// StartHealthChecks starts the HealthCheckers of every APIGatorTarget. The
+// targets with the same name and host on a previous configuration (if any)
+// keep their last health result until their first probe
+func (r *APIGatorRouter) StartHealthChecks(previous *APIGatorRouter) {
+	for _, target := range r.APIGatorTargets {
+		if previous != nil {
+			for _, old := range previous.APIGatorTargets {
+				if target.Name == old.Name && target.Host == old.Host && target.Port == old.Port {
+					target.Health.inherit(old.Health)
+					break
+				}
+			}
+		}
+		target.Health.Start()
+	}
+}
+
+// StopHealthChecks stops the HealthCheckers of every APIGatorTarget
+func (r *APIGatorRouter) StopHealthChecks() {
+	for _, target := range r.APIGatorTargets {
+		target.Health.Stop()
+	}
+}
+
+// HealthyTargets returns the number of healthy APIGatorTargets
+func (r *APIGatorRouter) HealthyTargets() int {
+	healthy := 0
+	for _, target := range r.APIGatorTargets {
+		if target.Health.State().Healthy {
+			healthy++
+		}
+	}
+	return healthy
 }
@@ -0,0 +1,70 @@
+package apigator
+
+import (
+	"strings"
+)
+
+const (
+	// RoutingFallbackBroadcast forwards the request to every APIGatorTarget
+	// when none of them serves its jurisdiction and manifest
+	RoutingFallbackBroadcast = "broadcast"
+	// RoutingFallbackReject rejects the request when none of the
+	// APIGatorTargets serves its jurisdiction and manifest
+	RoutingFallbackReject = "reject"
+)
+
+// RoutingRequest is the information of an incoming request used for
+// selecting the APIGatorTargets it's forwarded to
+type RoutingRequest struct {
+	CountryCode           string
+	DataOwningCountryCode string
+	ManifestName          string
+}
+
+// Serves reports if the APIGatorTarget serves the jurisdiction and manifest
+// of a request. A target without 'countries' serves every country, and one
+// without 'manifests' serves every manifest. Otherwise, the countryCode or
+// the dataOwningCountryCode of the request must be on its 'countries', and
+// the manifestName on its 'manifests'
+func (a *APIGatorTarget) Serves(req RoutingRequest) bool {
+	if len(a.Countries) > 0 && !contains(a.Countries, req.CountryCode) && !contains(a.Countries, req.DataOwningCountryCode) {
+		return false
+	}
+	if len(a.Manifests) > 0 && !contains(a.Manifests, req.ManifestName) {
+		return false
+	}
+	return true
+}
+
+// SelectTargets returns the APIGatorTargets serving the jurisdiction and
+// manifest of a request. When none of them does, it applies the
+// 'routing_fallback': every target on broadcast, none on reject. The second
+// value reports if the fallback was applied
+func (r *APIGatorRouter) SelectTargets(req RoutingRequest) ([]*APIGatorTarget, bool) {
+	var selected []*APIGatorTarget
+	for _, target := range r.APIGatorTargets {
+		if target.Serves(req) {
+			selected = append(selected, target)
+		}
+	}
+	if len(selected) > 0 {
+		return selected, false
+	}
+	if r.RoutingFallback == RoutingFallbackReject {
+		return nil, true
+	}
+	return r.APIGatorTargets, true
+}
+
+// contains reports if 'value' is on 'list', ignoring the case
+func contains(list []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimSpace(item), value) {
+			return true
+		}
+	}
+	return false
+}
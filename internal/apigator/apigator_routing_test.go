@@ -0,0 +1,82 @@
+package apigator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServes(t *testing.T) {
+	tests := []struct {
+		name      string
+		countries []string
+		manifests []string
+		req       RoutingRequest
+		want      bool
+	}{
+		{name: "serves everything", req: RoutingRequest{CountryCode: "GB", ManifestName: "Employee"}, want: true},
+		{name: "serves requests without jurisdiction", want: true},
+		{name: "country", countries: []string{"GB", "IE"}, req: RoutingRequest{CountryCode: "IE"}, want: true},
+		{name: "data owning country", countries: []string{"GB", "IE"}, req: RoutingRequest{CountryCode: "US", DataOwningCountryCode: "GB"}, want: true},
+		{name: "country not served", countries: []string{"GB", "IE"}, req: RoutingRequest{CountryCode: "US", DataOwningCountryCode: "DE"}},
+		{name: "missing country", countries: []string{"GB"}},
+		{name: "country ignoring case and spaces", countries: []string{" gb "}, req: RoutingRequest{CountryCode: "GB"}, want: true},
+		{name: "manifest", manifests: []string{"Employee", "Customer"}, req: RoutingRequest{ManifestName: "customer"}, want: true},
+		{name: "manifest not served", manifests: []string{"Employee"}, req: RoutingRequest{ManifestName: "Customer"}},
+		{name: "missing manifest", manifests: []string{"Employee"}, req: RoutingRequest{CountryCode: "GB"}},
+		{name: "country and manifest", countries: []string{"GB"}, manifests: []string{"Employee"}, req: RoutingRequest{CountryCode: "GB", ManifestName: "Employee"}, want: true},
+		{name: "country served, manifest not", countries: []string{"GB"}, manifests: []string{"Employee"}, req: RoutingRequest{CountryCode: "GB", ManifestName: "Customer"}},
+		{name: "manifest served, country not", countries: []string{"GB"}, manifests: []string{"Employee"}, req: RoutingRequest{CountryCode: "DE", ManifestName: "Employee"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &APIGatorTarget{Name: "test", Countries: tt.countries, Manifests: tt.manifests}
+			if got := target.Serves(tt.req); got != tt.want {
+				t.Errorf("Serves(%+v) = %v, want %v", tt.req, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectTargets(t *testing.T) {
+	uk := &APIGatorTarget{Name: "UK", Countries: []string{"GB"}}
+	germany := &APIGatorTarget{Name: "GERMANY", Countries: []string{"DE"}, Manifests: []string{"Employee"}}
+	global := &APIGatorTarget{Name: "GLOBAL"}
+
+	tests := []struct {
+		name         string
+		targets      []*APIGatorTarget
+		fallback     string
+		req          RoutingRequest
+		want         []*APIGatorTarget
+		wantFallback bool
+	}{
+		{name: "single match", targets: []*APIGatorTarget{uk, germany}, req: RoutingRequest{CountryCode: "GB"}, want: []*APIGatorTarget{uk}},
+		{name: "match and default target", targets: []*APIGatorTarget{uk, germany, global}, req: RoutingRequest{CountryCode: "DE", ManifestName: "Employee"}, want: []*APIGatorTarget{germany, global}},
+		{name: "only the default target", targets: []*APIGatorTarget{uk, germany, global}, req: RoutingRequest{CountryCode: "FR"}, want: []*APIGatorTarget{global}},
+		{name: "broadcast by default", targets: []*APIGatorTarget{uk, germany}, req: RoutingRequest{CountryCode: "FR"}, want: []*APIGatorTarget{uk, germany}, wantFallback: true},
+		{name: "broadcast", targets: []*APIGatorTarget{uk, germany}, fallback: RoutingFallbackBroadcast, req: RoutingRequest{CountryCode: "DE", ManifestName: "Customer"}, want: []*APIGatorTarget{uk, germany}, wantFallback: true},
+		{name: "reject", targets: []*APIGatorTarget{uk, germany}, fallback: RoutingFallbackReject, req: RoutingRequest{CountryCode: "FR"}, wantFallback: true},
+		{name: "reject without jurisdiction", targets: []*APIGatorTarget{uk, germany}, fallback: RoutingFallbackReject, wantFallback: true},
+		{name: "match with reject fallback", targets: []*APIGatorTarget{uk, germany}, fallback: RoutingFallbackReject, req: RoutingRequest{DataOwningCountryCode: "GB"}, want: []*APIGatorTarget{uk}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := &APIGatorRouter{APIGatorTargets: tt.targets, RoutingFallback: tt.fallback}
+			got, fallback := router.SelectTargets(tt.req)
+			if !reflect.DeepEqual(got, tt.want) || fallback != tt.wantFallback {
+				t.Errorf("SelectTargets(%+v) = %v, %v, want %v, %v", tt.req, names(got), fallback, names(tt.want), tt.wantFallback)
+			}
+		})
+	}
+}
+
+// names returns the names of the targets
+func names(targets []*APIGatorTarget) []string {
+	var list []string
+	for _, target := range targets {
+		list = append(list, target.Name)
+	}
+	return list
+}
@@ -0,0 +1,101 @@
+package apigator
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	// SchemaModeDisqualify discards the responses not valid against the schema
+	SchemaModeDisqualify = "disqualify"
+	// SchemaModePenalise reduces the score of the responses not valid against the schema
+	SchemaModePenalise = "penalise"
+)
+
+// SchemaRegistry holds the JSON Schemas the dataSet of the responses must
+// comply with, indexed by the 'manifestName' of the request
+type SchemaRegistry struct {
+	schemas map[string]*jsonschema.Schema
+	mode    string
+	penalty float64
+}
+
+// ResponseSchema is the JSON Schema for the responses of a specific manifest
+type ResponseSchema struct {
+	Manifest string
+	schema   *jsonschema.Schema
+	mode     string
+	penalty  float64
+}
+
+// NewSchemaRegistry compiles the JSON Schema files indexed by manifest name.
+// Responses not valid against their schema are disqualified on
+// SchemaModeDisqualify or get their score reduced by the 'penalty' fraction
+// on SchemaModePenalise
+func NewSchemaRegistry(files map[string]string, mode string, penalty float64) (*SchemaRegistry, error) {
+	switch mode {
+	case SchemaModeDisqualify:
+	case SchemaModePenalise:
+		if penalty <= 0 || penalty > 1 {
+			return nil, fmt.Errorf("schema_penalty must be greater than 0.0 and lower or equal than 1.0")
+		}
+	default:
+		return nil, fmt.Errorf("unknown schema_mode: %q", mode)
+	}
+
+	registry := &SchemaRegistry{
+		schemas: make(map[string]*jsonschema.Schema, len(files)),
+		mode:    mode,
+		penalty: penalty,
+	}
+	compiler := jsonschema.NewCompiler()
+	for manifest, file := range files {
+		schema, err := compiler.Compile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile JSON Schema for manifest %q: %v", manifest, err)
+		}
+		registry.schemas[manifest] = schema
+	}
+
+	return registry, nil
+}
+
+// Lookup returns the schema for a manifest, or nil if the manifest has no
+// schema or there is no registry
+func (s *SchemaRegistry) Lookup(manifest string) *ResponseSchema {
+	if s == nil {
+		return nil
+	}
+	schema, exists := s.schemas[manifest]
+	if !exists {
+		return nil
+	}
+	return &ResponseSchema{Manifest: manifest, schema: schema, mode: s.mode, penalty: s.penalty}
+}
+
+// Apply validates the dataSet of a response against the schema and returns
+// the score updated with the result. Valid responses keep their score
+func (s *ResponseSchema) Apply(dataSet string, score float64, target string, logger *zap.Logger) float64 {
+	var doc interface{}
+	err := json.Unmarshal([]byte(dataSet), &doc)
+	if err == nil {
+		err = s.schema.Validate(doc)
+	}
+	if err == nil {
+		return score
+	}
+
+	fields := []zap.Field{
+		zap.String("apigator", target),
+		zap.String("manifest_name", s.Manifest),
+		zap.String("validation_errors", fmt.Sprintf("%#v", err)),
+	}
+	if s.mode == SchemaModeDisqualify {
+		logger.Warn("Response does not comply with the manifest schema. Discarding...", fields...)
+		return -1.0
+	}
+	logger.Warn("Response does not comply with the manifest schema. Penalising...", append(fields, zap.Float64("penalty", s.penalty))...)
+	return score * (bestResponseScore - s.penalty)
+}
@@ -5,157 +5,388 @@ package apigator
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"exate-dora-router/internal/metrics"
+	"exate-dora-router/internal/secrets"
+	"exate-dora-router/internal/tracing"
 	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
 	"sync"
-)
-
-const (
-	// MAX_ATTEMPTS represents the maximum number of attempts that the DoraRouter will try to reach a APIGator instance
-	MAX_ATTEMPTS = 5
+	"time"
 )
 
 // APIGatorTarget represents and APIGator server and authentication information for forwarding the incoming requests
 type APIGatorTarget struct {
-	Name         string `ini:"name"`
-	Host         string `ini:"host"`
-	Port         int    `ini:"port"`
-	ClientID     string `ini:"client_id"`
-	ClientSecret string `ini:"client_secret"`
-	ApiKey       string `ini:"api_key"`
-	Token        string
+	Name         string  `ini:"name"`
+	Host         string  `ini:"host"`
+	Port         int     `ini:"port"`
+	ClientID     string  `ini:"client_id"`
+	ClientSecret string  `ini:"client_secret"` // plain value or secret reference
+	ApiKey       string  `ini:"api_key"`       // plain value or secret reference
+	Priority     float64 `ini:"priority"`
+	Group        string  `ini:"group"` // name of the [group_*] section inherited
+	Tokens       *TokenManager
+	Breaker      *CircuitBreaker
+	Retry        *RetryPolicy
+	Health       *HealthChecker
+	Latencies    map[string]*LatencyTracker // by host and alternate host
 	Client       *http.Client
 	Config       *APIGatorConfig
 	Logger       *zap.Logger
+
+	// Replicas of the APIGator host receiving the hedged requests
+	AlternateHosts []string `ini:"alternate_hosts"`
+	// Country codes and manifest names served by the target. Empty means
+	// every one
+	Countries []string `ini:"countries"`
+	Manifests []string `ini:"manifests"`
+	// Country code where the target runs, for the data-residency policy
+	Location string `ini:"location"`
+
+	// Secrets resolved from ClientSecret and ApiKey
+	clientSecret *secrets.Secret
+	apiKey       *secrets.Secret
+
+	// Next alternate host for hedging
+	hedgeMu   sync.Mutex
+	hedgeNext int
+}
+
+// StatusError is returned when APIGator answers with a status code other than
+// 200 (OK)
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+// Error returns the status code and the body of the response
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Request failed. Response Code: %d. HTTP response body: %s", e.StatusCode, e.Body)
+}
+
+// ResolveSecrets resolves the client_secret and api_key references of the
+// APIGatorTarget. It must be called before forwarding any request
+func (a *APIGatorTarget) ResolveSecrets() error {
+	var err error
+	if a.clientSecret, err = secrets.Resolve(a.ClientSecret); err != nil {
+		return fmt.Errorf("failed to resolve client_secret: %v", err)
+	}
+	if a.apiKey, err = secrets.Resolve(a.ApiKey); err != nil {
+		return fmt.Errorf("failed to resolve api_key: %v", err)
+	}
+	return nil
+}
+
+// sameCredentials reports if two APIGatorTargets request their tokens to the
+// same identity endpoint with the same credentials
+func (a *APIGatorTarget) sameCredentials(b *APIGatorTarget) bool {
+	return a.Name == b.Name &&
+		a.Host == b.Host &&
+		a.Port == b.Port &&
+		a.ClientID == b.ClientID &&
+		a.ClientSecret == b.ClientSecret &&
+		a.ApiKey == b.ApiKey &&
+		a.Config.AuthHost == b.Config.AuthHost &&
+		a.Config.AuthPath == b.Config.AuthPath &&
+		a.Config.GrantType == b.Config.GrantType
+}
+
+// datasetURL returns the URL of the dataset endpoint of APIGator on 'host',
+// the host of the target or one of its alternate hosts
+func (a *APIGatorTarget) datasetURL(host string) string {
+	return hostWithPort(host, a.Port) + a.Config.DatasetPath
+}
+
+// authURL returns the URL for requesting the Access Tokens. It's on the
+// APIGator host unless a separate identity host is configured
+func (a *APIGatorTarget) authURL() string {
+	if a.Config.AuthHost != "" {
+		return strings.TrimRight(a.Config.AuthHost, "/") + a.Config.AuthPath
+	}
+	return hostWithPort(a.Host, a.Port) + a.Config.AuthPath
+}
+
+// hostWithPort adds the port to a host URL without an explicit port. The
+// port is omitted when it's the default of the URL scheme
+func hostWithPort(host string, port int) string {
+	host = strings.TrimRight(host, "/")
+	hostURL, err := url.Parse(host)
+	if port == 0 || err != nil || hostURL.Host == "" || hostURL.Port() != "" {
+		return host
+	}
+	if (hostURL.Scheme == "https" && port == 443) || (hostURL.Scheme == "http" && port == 80) {
+		return host
+	}
+	hostURL.Host = fmt.Sprintf("%s:%d", hostURL.Host, port)
+	return hostURL.String()
 }
 
 // requestNewAccessToken uses the client_id and client_secret for obtainning a
 // new Bearer Access Token from APIGator. If the response from APIGator is
-// correct, it automatically saves the obtained token into the APIGatorTarget
-// object. If it fails, and error is returned but the 'token' field is not updated
-func (a *APIGatorTarget) requestNewAccessToken() error {
+// correct, it returns the obtained token. The token is kept by the
+// TokenManager of the APIGatorTarget, which is the only caller of this method
+func (a *APIGatorTarget) requestNewAccessToken(ctx context.Context) (tokenResponse *TokenResponse, err error) {
+	ctx, span := tracing.Start(ctx, "APIGator token refresh",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("apigator.target", a.Name)),
+	)
+	defer func() { tracing.End(span, err) }()
+
 	a.Logger.Info("Requesting a new Access Token for APIGator", zap.String("apigator_target", a.Name))
 
+	// Secrets are read every time, so rotated secrets are used right away
+	clientSecret, err := a.clientSecret.Value()
+	if err != nil {
+		return nil, err
+	}
+	apiKey, err := a.apiKey.Value()
+	if err != nil {
+		return nil, err
+	}
+
 	// Building Token HTTP Request Body
 	data := url.Values{}
 	data.Set("client_id", a.ClientID)
-	data.Set("client_secret", a.ClientSecret)
+	data.Set("client_secret", clientSecret)
 	data.Set("grant_type", a.Config.GrantType)
 
 	// Create the request body with the credentials
-	req, err := http.NewRequest("POST", a.Host+a.Config.AuthPath, strings.NewReader(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", a.authURL(), strings.NewReader(data.Encode()))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Setting Token Request Headers
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("X-API-Key", a.ApiKey)
+	req.Header.Set("X-API-Key", apiKey)
+	tracing.Inject(ctx, req.Header)
 
 	// Access Token HTTP Request
 	resp, err := a.Client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Reading Token
 	defer resp.Body.Close()
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// If the Response code is 200OK, set the new token for the APIGatorTarget, if not, return err
-	if resp.StatusCode == http.StatusOK {
-		a.Logger.Info("Obtained new AccessToken for APIGator", zap.String("apigator_target", a.Name))
-		var tokenResponse TokenResponse
-		if err := json.Unmarshal(bodyBytes, &tokenResponse); err != nil {
-			return err
-		}
-		a.Token = tokenResponse.AccessToken
-	} else {
-		return fmt.Errorf("Unexpected response status code (%d) when requesting a new AccessToken. Response: %s", resp.StatusCode, string(bodyBytes))
+	// If the Response code is 200OK, return the new token for the APIGatorTarget, if not, return err
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected response status code (%d) when requesting a new AccessToken. Response: %s", resp.StatusCode, string(bodyBytes))
 	}
 
-	return nil
+	a.Logger.Info("Obtained new AccessToken for APIGator", zap.String("apigator_target", a.Name))
+	tokenResponse = &TokenResponse{}
+	if err := json.Unmarshal(bodyBytes, tokenResponse); err != nil {
+		return nil, err
+	}
+
+	return tokenResponse, nil
 }
 
 // UpdateRequestHeaders adds the needed HTTP headers to the incoming request
 // for a correct interaction and authentication with an APIGator instance
-func (a *APIGatorTarget) UpdateRequestHeaders(req *http.Request) error {
+func (a *APIGatorTarget) UpdateRequestHeaders(req *http.Request, token string) error {
 	if req == nil {
 		return fmt.Errorf("Cannot Update HTTP headers on a NULL or empty request")
 	}
 
-	req.Header.Set("X-Resource-Token", "Bearer "+a.Token)
-	req.Header.Set("X-API-Key", a.ApiKey)
+	apiKey, err := a.apiKey.Value()
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("X-Resource-Token", "Bearer "+token)
+	req.Header.Set("X-API-Key", apiKey)
 	req.Header.Set("X-Data-Set-Type", "JSON")
 	req.Header.Set("Content-Type", "application/json")
 
 	return nil
 }
 
-// ForwardRequestToAPIGator takes an array of bytes as the body of a HTTP request and forwards it to its APIGator instance
-// If the APIGator returns 401 (Unauthorized) it requests a new Access Token, creates a new request with the updated Headers and try again
-// If the APIGator returns 400 (Bad Request) it creates a new request with the updated Headers and try again
-// If the APIGator returns 200 (OK) it finishes and returns the response
-func (a *APIGatorTarget) ForwardRequestToAPIGator(wg *sync.WaitGroup, body []byte, responseChan chan<- APIGatorResponse) error {
-	for attempts := 0; attempts < MAX_ATTEMPTS; attempts++ {
-		var resp *http.Response
+// send performs a single attempt of sending the request body to APIGator on
+// 'host' with the current Access Token, requesting a new one if there is no
+// valid token. It returns the response and the token used
+func (a *APIGatorTarget) send(ctx context.Context, host string, body []byte, attempt int) (resp *http.Response, token string, err error) {
+	ctx, span := tracing.Start(ctx, "APIGator attempt",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("apigator.target", a.Name),
+			attribute.Int("apigator.attempt", attempt),
+			attribute.Bool("apigator.hedge", host != a.Host),
+		),
+	)
+	defer func() {
+		if resp != nil {
+			span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		}
+		tracing.End(span, err)
+	}()
 
-		// Creating Request
-		req, err := http.NewRequest("POST", a.Host+a.Config.DatasetPath, bytes.NewBuffer(body))
-		if err != nil {
-			a.Logger.Error("Failed to create request", zap.String("apigator_target", a.Name), zap.Error(err))
-			return err
+	// Creating Request
+	req, err := http.NewRequestWithContext(ctx, "POST", a.datasetURL(host), bytes.NewBuffer(body))
+	if err != nil {
+		a.Logger.Error("Failed to create request", zap.String("apigator_target", a.Name), zap.Error(err))
+		return nil, "", err
+	}
+
+	// Getting the Access Token. A new one is requested if there is no valid token
+	token, err = a.Tokens.Token(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Setting headers for APIGator, including the trace context
+	if err := a.UpdateRequestHeaders(req, token); err != nil {
+		return nil, "", err
+	}
+	tracing.Inject(ctx, req.Header)
+
+	a.Logger.Debug("Performing HTTP Request on to APIGator",
+		zap.String("apigator_target", a.Name),
+		zap.String("url", req.URL.String()),
+		zap.Int("try", attempt))
+
+	// Forwarding HTTP request to APIGator
+	requestStart := time.Now()
+	resp, err = a.Client.Do(req)
+	latency := time.Since(requestStart)
+	if err != nil {
+		metrics.ObserveRequest(a.Name, 0, latency)
+		// A request cancelled or timed out before its response took at least
+		// this long, so leaving it out would make the host look faster
+		if ctx.Err() != nil || os.IsTimeout(err) {
+			a.observeLatency(host, latency)
 		}
+		return nil, "", err
+	}
+	metrics.ObserveRequest(a.Name, resp.StatusCode, latency)
+	a.observeLatency(host, latency)
+	return resp, token, nil
+}
+
+// RecordResult feeds the CircuitBreaker with the result of
+// ForwardRequestToAPIGator. Requests cancelled by the router, because a
+// response was already selected or the requester disconnected, don't count,
+// and neither do the 4xx responses, which are caused by the request itself. A
+// request aborted by the router deadline or the target timeout is a failure
+func (a *APIGatorTarget) RecordResult(err error, cancelled bool) {
+	var statusErr *StatusError
+	switch {
+	case err == nil:
+		a.Breaker.Success()
+	case cancelled:
+		a.Breaker.Cancelled()
+	case errors.As(err, &statusErr) && statusErr.StatusCode < 500:
+		a.Breaker.Success()
+	default:
+		a.Breaker.Failure()
+	}
+}
 
-		// Setting headers for APIGator
-		if err := a.UpdateRequestHeaders(req); err != nil {
+// ForwardRequestToAPIGator takes an array of bytes as the body of a HTTP request and forwards it to its APIGator instance
+// If the APIGator returns 401 (Unauthorized) it requests a new Access Token, creates a new request with the updated Headers and try again
+// If the APIGator returns a status code retryable by the RetryPolicy of the target (or the request fails without a
+// response and the policy retries transport errors), it waits for the backoff delay and tries again
+// If the APIGator returns 200 (OK) it finishes and returns the response
+// Cancelling the context (requester disconnected, router deadline reached or
+// response already selected) aborts the request in-flight, the token refresh
+// and the pending attempts
+func (a *APIGatorTarget) ForwardRequestToAPIGator(ctx context.Context, wg *sync.WaitGroup, body []byte, responseChan chan<- APIGatorResponse) error {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		// No more attempts when the context is already done
+		if err := ctx.Err(); err != nil {
 			return err
 		}
 
-		a.Logger.Debug("Performing HTTP Request on to APIGator",
-			zap.String("apigator_target", a.Name),
-			zap.String("url", req.URL.String()),
-			zap.Int("try", attempts))
-
-		// Forwarding HTTP request to APIGator
-		resp, err = a.Client.Do(req)
+		// Sending the request with the current Access Token
+		resp, token, err := a.sendHedged(ctx, body, attempt-1)
 		if err != nil {
-			return err
+			if ctx.Err() != nil || !a.Retry.transportErrors {
+				return err
+			}
+			if err := a.retry(ctx, attempt, start, err, 0); err != nil {
+				return err
+			}
+			continue
 		}
 
 		// Checking the response Code
 		if resp.StatusCode == http.StatusUnauthorized { // If there is no token yet, or the token has expired (401 Unauthorized)
 			a.Logger.Warn("Token Expired for APIGator", zap.String("apigator_target", a.Name))
-			if err := a.requestNewAccessToken(); err != nil {
+			resp.Body.Close()
+			if err := a.Tokens.Refresh(ctx, token); err != nil {
 				return err
 			}
+			// Retried right away, as the new token fixes the request
+			if !a.Retry.allows(attempt, start, 0) {
+				return fmt.Errorf("Maximum attempts reached (%d). Request failed: %w", attempt, &StatusError{StatusCode: resp.StatusCode})
+			}
+			metrics.ObserveRetry(a.Name)
 			continue
 		} else if resp.StatusCode == http.StatusOK { // Response correct (200 OK)
 			a.Logger.Debug("Response correct from APIGator")
+			// Reading the body before handing the response over, so it can still
+			// be evaluated after the request context is cancelled
+			respBodyBytes, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return err
+			}
+			resp.Body = ioutil.NopCloser(bytes.NewBuffer(respBodyBytes))
 			responseChan <- APIGatorResponse{
 				Response: *resp,
 				Name:     a.Name,
+				Priority: a.Priority,
+				Latency:  time.Since(start),
 			}
 			return nil
-		} else if resp.StatusCode >= 400 && resp.StatusCode <= 600 { // Every HTTP RC 4XX and 5XX
-			respBodyBytes, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				return err
-			}
-			return fmt.Errorf("Request failed. Response Code: %d. HTTP response body: %s\n", resp.StatusCode, string(respBodyBytes))
-		} else {
-			a.Logger.Warn("Request is not correct. Trying again", zap.Int("status_code", resp.StatusCode))
-			continue
+		}
+
+		// Any other status code fails the request, unless the policy retries it
+		respBodyBytes, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		statusErr := &StatusError{StatusCode: resp.StatusCode, Body: string(respBodyBytes)}
+		if !a.Retry.retryableStatus(resp.StatusCode) {
+			return statusErr
+		}
+		if err := a.retry(ctx, attempt, start, statusErr, retryAfter(resp)); err != nil {
+			return err
 		}
 	}
+}
 
-	return fmt.Errorf("Maximmum tries reached. Request failed")
+// retry waits before attempting again a request failed with 'failure', after
+// 'attempts' attempts since 'start'. It returns an error if the RetryPolicy
+// doesn't allow a new attempt or the context is done while waiting
+func (a *APIGatorTarget) retry(ctx context.Context, attempts int, start time.Time, failure error, retryAfter time.Duration) error {
+	delay := a.Retry.delay(attempts, retryAfter)
+	if !a.Retry.allows(attempts, start, delay) {
+		return fmt.Errorf("Retries exhausted after %d attempts: %w", attempts, failure)
+	}
+	a.Logger.Warn("Request to APIGator failed. Trying again",
+		zap.String("apigator_target", a.Name),
+		zap.Int("try", attempts),
+		zap.Duration("delay", delay),
+		zap.Error(failure),
+	)
+	metrics.ObserveRetry(a.Name)
+	return wait(ctx, delay)
 }
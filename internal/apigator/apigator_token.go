@@ -0,0 +1,305 @@
+package apigator
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"exate-dora-router/internal/metrics"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTokenRefreshAhead is how long before its expiration a token is
+	// refreshed when 'token_refresh_ahead' is not configured
+	defaultTokenRefreshAhead = 60 * time.Second
+	// minTokenRefreshDelay is the shortest delay before a background refresh,
+	// so tokens living less than 'token_refresh_ahead' don't chain refreshes
+	minTokenRefreshDelay = time.Second
+	// tokenRetryInterval is the delay before retrying a failed background
+	// refresh. It doubles on every consecutive failure up to tokenMaxRetryInterval
+	tokenRetryInterval = 10 * time.Second
+	// tokenMaxRetryInterval is the longest delay between failed background
+	// refreshes
+	tokenMaxRetryInterval = 5 * time.Minute
+)
+
+// TokenState describes the Access Token of an APIGatorTarget without
+// exposing it
+type TokenState struct {
+	Valid       bool       `json:"valid"`
+	ValidUntil  *time.Time `json:"valid_until,omitempty"`
+	LastRefresh *time.Time `json:"last_refresh,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// tokenRefresh is a token request in-flight, shared by every caller waiting
+// for a new token
+type tokenRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+// TokenManager keeps the Access Token of an APIGatorTarget. It can be used
+// concurrently: simultaneous refreshes are collapsed into a single request,
+// and tokens with a known expiration are refreshed in background before they
+// expire
+type TokenManager struct {
+	target       *APIGatorTarget
+	refreshAhead time.Duration
+
+	mu          sync.Mutex
+	token       string
+	validUntil  time.Time
+	lastRefresh time.Time
+	lastErr     error
+	failures    int // consecutive failed refreshes
+	inflight    *tokenRefresh
+	timer       *time.Timer
+	closed      bool
+}
+
+// NewTokenManager returns the TokenManager for an APIGatorTarget. Tokens are
+// refreshed 'refreshAhead' before their expiration. 0 means the default
+// time, and a negative value disables the background refresh
+func NewTokenManager(target *APIGatorTarget, refreshAhead time.Duration) *TokenManager {
+	if refreshAhead == 0 {
+		refreshAhead = defaultTokenRefreshAhead
+	}
+	return &TokenManager{target: target, refreshAhead: refreshAhead}
+}
+
+// Token returns the current Access Token, requesting a new one if there is no
+// token or it has expired
+func (m *TokenManager) Token(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	if m.token != "" && (m.validUntil.IsZero() || time.Now().Before(m.validUntil)) {
+		token := m.token
+		m.mu.Unlock()
+		return token, nil
+	}
+	refresh := m.startRefresh(ctx)
+	m.mu.Unlock()
+
+	if err := m.wait(ctx, refresh); err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.token, nil
+}
+
+// Refresh requests a new Access Token because 'stale' was rejected by
+// APIGator. If the token was already replaced by another caller, no request
+// is performed
+func (m *TokenManager) Refresh(ctx context.Context, stale string) error {
+	m.mu.Lock()
+	if m.token != stale && m.token != "" {
+		m.mu.Unlock()
+		return nil
+	}
+	refresh := m.startRefresh(ctx)
+	m.mu.Unlock()
+
+	return m.wait(ctx, refresh)
+}
+
+// Renew requests a new Access Token even if the current one is still valid,
+// so the credentials are checked again against the identity provider. The
+// current token is kept if the request fails
+func (m *TokenManager) Renew(ctx context.Context) error {
+	m.mu.Lock()
+	refresh := m.startRefresh(ctx)
+	m.mu.Unlock()
+
+	return m.wait(ctx, refresh)
+}
+
+// State returns the state of the Access Token
+func (m *TokenManager) State() TokenState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := TokenState{
+		Valid: m.token != "" && (m.validUntil.IsZero() || time.Now().Before(m.validUntil)),
+	}
+	if !m.validUntil.IsZero() {
+		validUntil := m.validUntil
+		state.ValidUntil = &validUntil
+	}
+	if !m.lastRefresh.IsZero() {
+		lastRefresh := m.lastRefresh
+		state.LastRefresh = &lastRefresh
+	}
+	if m.lastErr != nil {
+		state.LastError = m.lastErr.Error()
+	}
+	return state
+}
+
+// Close stops the background refresh
+func (m *TokenManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+}
+
+// rebind makes the TokenManager request the next tokens on behalf of another
+// APIGatorTarget with the same credentials
+func (m *TokenManager) rebind(target *APIGatorTarget) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.target = target
+}
+
+// startRefresh returns the token request in-flight, starting a new one if
+// there is none. A new request is traced as part of the caller's trace, but
+// it's not cancelled with the caller's context. It must be called holding the
+// lock
+func (m *TokenManager) startRefresh(ctx context.Context) *tokenRefresh {
+	if m.inflight != nil {
+		return m.inflight
+	}
+
+	refresh := &tokenRefresh{done: make(chan struct{})}
+	m.inflight = refresh
+	go m.refresh(context.WithoutCancel(ctx), refresh)
+	return refresh
+}
+
+// wait blocks until the refresh finishes or the context is done
+func (m *TokenManager) wait(ctx context.Context, refresh *tokenRefresh) error {
+	select {
+	case <-refresh.done:
+		return refresh.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// refresh requests a new Access Token and saves it. The context must not be
+// cancellable, so a caller leaving doesn't abort the refresh for the rest
+func (m *TokenManager) refresh(ctx context.Context, refresh *tokenRefresh) {
+	m.mu.Lock()
+	target := m.target
+	m.mu.Unlock()
+
+	tokenResponse, err := target.requestNewAccessToken(ctx)
+	metrics.ObserveTokenRefresh(target.Name, err)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.lastRefresh = now
+	m.lastErr = err
+	if err != nil {
+		m.failures++
+	} else {
+		m.failures = 0
+		m.token = tokenResponse.AccessToken
+		m.validUntil = tokenExpiration(tokenResponse, now)
+		m.target.Logger.Debug("Access Token updated",
+			zap.String("apigator_target", m.target.Name),
+			zap.Time("valid_until", m.validUntil),
+		)
+	}
+	m.scheduleRefresh(now)
+
+	m.inflight = nil
+	refresh.err = err
+	close(refresh.done)
+}
+
+// scheduleRefresh programs the background refresh of a token with a known
+// expiration. It must be called holding the lock
+func (m *TokenManager) scheduleRefresh(now time.Time) {
+	if m.closed || m.refreshAhead < 0 {
+		return
+	}
+	delay, scheduled := m.refreshDelay(now)
+	if !scheduled {
+		return
+	}
+
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+	m.timer = time.AfterFunc(delay, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if !m.closed {
+			m.target.Logger.Debug("Refreshing Access Token before expiration", zap.String("apigator_target", m.target.Name))
+			m.startRefresh(context.Background())
+		}
+	})
+}
+
+// refreshDelay returns the time until the next background refresh after the
+// one finished at 'now'. Tokens are refreshed 'refreshAhead' before their
+// expiration, or halfway through their lifetime if it's shorter, and never
+// sooner than minTokenRefreshDelay. Failed refreshes are retried with an
+// exponential backoff while the token is still valid. It reports false if no
+// refresh must be scheduled. It must be called holding the lock
+func (m *TokenManager) refreshDelay(now time.Time) (time.Duration, bool) {
+	if m.validUntil.IsZero() {
+		return 0, false
+	}
+
+	var delay time.Duration
+	if m.lastErr != nil {
+		if now.After(m.validUntil) {
+			return 0, false
+		}
+		delay = tokenRetryInterval
+		for i := 1; i < m.failures && delay < tokenMaxRetryInterval; i++ {
+			delay *= 2
+		}
+		if delay > tokenMaxRetryInterval {
+			delay = tokenMaxRetryInterval
+		}
+	} else if lifetime := m.validUntil.Sub(now); lifetime > m.refreshAhead {
+		delay = lifetime - m.refreshAhead
+	} else {
+		delay = lifetime / 2
+	}
+
+	if delay < minTokenRefreshDelay {
+		delay = minTokenRefreshDelay
+	}
+	return delay, true
+}
+
+// tokenExpiration returns when a token expires based on the 'expires_in'
+// field of the token response or, if not defined, on the 'exp' claim of the
+// token if it's a JWT. If none of them is available, the zero time is returned
+func tokenExpiration(tokenResponse *TokenResponse, now time.Time) time.Time {
+	if tokenResponse.ExpiresIn > 0 {
+		return now.Add(time.Duration(tokenResponse.ExpiresIn) * time.Second)
+	}
+
+	parts := strings.Split(tokenResponse.AccessToken, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp float64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(claims.Exp), 0)
+}
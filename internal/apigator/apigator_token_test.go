@@ -0,0 +1,259 @@
+package apigator
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestTarget returns an APIGatorTarget whose APIGator and identity
+// endpoints are on 'host'
+func newTestTarget(t *testing.T, host string) *APIGatorTarget {
+	t.Helper()
+
+	target := &APIGatorTarget{
+		Name:         "test",
+		Host:         host,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		ApiKey:       "key",
+		Client:       http.DefaultClient,
+		Config: &APIGatorConfig{
+			DatasetPath: defaultDatasetPath,
+			AuthPath:    defaultAuthPath,
+			GrantType:   defaultGrantType,
+		},
+		Logger: zap.NewNop(),
+	}
+	if err := target.ResolveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	return target
+}
+
+// newTestIdP starts an identity server answering the token request number 'n'
+// with 'response(n)'. Every request waits for 'release' to be closed, if not
+// nil. It returns the number of token requests received
+func newTestIdP(t *testing.T, response func(n int64) string, release chan struct{}) (*APIGatorTarget, *atomic.Int64) {
+	t.Helper()
+
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		if release != nil {
+			<-release
+		}
+		fmt.Fprint(w, response(n))
+	}))
+	t.Cleanup(server.Close)
+	return newTestTarget(t, server.URL), &requests
+}
+
+// expiringIn returns the tokens "token-1", "token-2"... with an 'expires_in'
+// of 'seconds'
+func expiringIn(seconds int) func(int64) string {
+	return func(n int64) string {
+		return fmt.Sprintf(`{"access_token":"token-%d","expires_in":%d}`, n, seconds)
+	}
+}
+
+// jwt returns a JWT with the 'claims'
+func jwt(claims string) string {
+	return "header." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".signature"
+}
+
+func TestTokenExpiration(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name     string
+		response TokenResponse
+		want     time.Time
+	}{
+		{name: "expires_in", response: TokenResponse{AccessToken: "opaque", ExpiresIn: 30}, want: now.Add(30 * time.Second)},
+		{name: "expires_in wins over exp", response: TokenResponse{AccessToken: jwt(`{"exp":1700000100}`), ExpiresIn: 30}, want: now.Add(30 * time.Second)},
+		{name: "JWT exp", response: TokenResponse{AccessToken: jwt(`{"exp":1700000100}`)}, want: time.Unix(1700000100, 0)},
+		{name: "JWT without exp", response: TokenResponse{AccessToken: jwt(`{"sub":"client"}`)}},
+		{name: "invalid JWT payload", response: TokenResponse{AccessToken: "header.%%%.signature"}},
+		{name: "opaque token", response: TokenResponse{AccessToken: "opaque"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokenExpiration(&tt.response, now); !got.Equal(tt.want) {
+				t.Errorf("tokenExpiration = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenManagerRefreshDelay(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name          string
+		validFor      time.Duration
+		noExpiration  bool
+		failures      int
+		wantDelay     time.Duration
+		wantScheduled bool
+	}{
+		{name: "unknown expiration", noExpiration: true},
+		{name: "long lived token", validFor: time.Hour, wantDelay: time.Hour - time.Minute, wantScheduled: true},
+		{name: "lifetime shorter than refresh ahead", validFor: 30 * time.Second, wantDelay: 15 * time.Second, wantScheduled: true},
+		{name: "lifetime equal to refresh ahead", validFor: time.Minute, wantDelay: 30 * time.Second, wantScheduled: true},
+		{name: "very short lifetime", validFor: time.Second, wantDelay: minTokenRefreshDelay, wantScheduled: true},
+		{name: "already expired", validFor: -time.Minute, wantDelay: minTokenRefreshDelay, wantScheduled: true},
+		{name: "first failure", validFor: time.Hour, failures: 1, wantDelay: tokenRetryInterval, wantScheduled: true},
+		{name: "third failure", validFor: time.Hour, failures: 3, wantDelay: 4 * tokenRetryInterval, wantScheduled: true},
+		{name: "backoff limit", validFor: time.Hour, failures: 20, wantDelay: tokenMaxRetryInterval, wantScheduled: true},
+		{name: "failure after expiration", validFor: -time.Second, failures: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewTokenManager(nil, 0)
+			if !tt.noExpiration {
+				m.validUntil = now.Add(tt.validFor)
+			}
+			if tt.failures > 0 {
+				m.failures = tt.failures
+				m.lastErr = fmt.Errorf("refresh failed")
+			}
+
+			delay, scheduled := m.refreshDelay(now)
+			if delay != tt.wantDelay || scheduled != tt.wantScheduled {
+				t.Errorf("refreshDelay = %v, %v, want %v, %v", delay, scheduled, tt.wantDelay, tt.wantScheduled)
+			}
+		})
+	}
+}
+
+func TestTokenManagerShortLivedTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		response func(int64) string
+	}{
+		{name: "lifetime shorter than refresh ahead", response: expiringIn(1)},
+		{name: "JWT already expired", response: func(int64) string {
+			return fmt.Sprintf(`{"access_token":%q}`, jwt(fmt.Sprintf(`{"exp":%d}`, time.Now().Add(-time.Minute).Unix())))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, requests := newTestIdP(t, tt.response, nil)
+			target.Tokens = NewTokenManager(target, 0)
+			defer target.Tokens.Close()
+
+			if _, err := target.Tokens.Token(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(minTokenRefreshDelay / 2)
+			if n := requests.Load(); n != 1 {
+				t.Errorf("%d token requests, want 1", n)
+			}
+		})
+	}
+}
+
+func TestTokenManagerConcurrentToken(t *testing.T) {
+	release := make(chan struct{})
+	target, requests := newTestIdP(t, expiringIn(3600), release)
+	target.Tokens = NewTokenManager(target, 0)
+	defer target.Tokens.Close()
+
+	const callers = 20
+	tokens := make(chan string, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := target.Tokens.Token(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+			tokens <- token
+		}()
+	}
+	// Every caller waits for the same request
+	for requests.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(tokens)
+
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d token requests, want 1", n)
+	}
+	for token := range tokens {
+		if token != "token-1" {
+			t.Errorf("Token = %q, want %q", token, "token-1")
+		}
+	}
+}
+
+func TestTokenManagerRefresh(t *testing.T) {
+	target, requests := newTestIdP(t, expiringIn(3600), nil)
+	target.Tokens = NewTokenManager(target, 0)
+	defer target.Tokens.Close()
+	ctx := context.Background()
+
+	steps := []struct {
+		name         string
+		refresh      string
+		wantToken    string
+		wantRequests int64
+	}{
+		{name: "first token", wantToken: "token-1", wantRequests: 1},
+		{name: "cached token", wantToken: "token-1", wantRequests: 1},
+		{name: "rejected token", refresh: "token-1", wantToken: "token-2", wantRequests: 2},
+		{name: "token already replaced", refresh: "token-1", wantToken: "token-2", wantRequests: 2},
+		{name: "new token rejected", refresh: "token-2", wantToken: "token-3", wantRequests: 3},
+	}
+
+	for _, s := range steps {
+		if s.refresh != "" {
+			if err := target.Tokens.Refresh(ctx, s.refresh); err != nil {
+				t.Fatalf("%s: %v", s.name, err)
+			}
+		}
+		token, err := target.Tokens.Token(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if token != s.wantToken || requests.Load() != s.wantRequests {
+			t.Errorf("%s: Token = %q after %d requests, want %q after %d", s.name, token, requests.Load(), s.wantToken, s.wantRequests)
+		}
+	}
+}
+
+func TestTokenManagerState(t *testing.T) {
+	target, _ := newTestIdP(t, expiringIn(3600), nil)
+	target.Tokens = NewTokenManager(target, 0)
+	defer target.Tokens.Close()
+
+	state := target.Tokens.State()
+	if state.Valid || state.ValidUntil != nil || state.LastRefresh != nil {
+		t.Errorf("State before the first token = %+v", state)
+	}
+
+	if _, err := target.Tokens.Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	state = target.Tokens.State()
+	if !state.Valid || state.ValidUntil == nil || state.LastRefresh == nil || state.LastError != "" {
+		t.Errorf("State = %+v", state)
+	}
+}
@@ -0,0 +1,128 @@
+package apigator
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	// defaultMaxDepth is the nesting limit of the documents walked by the
+	// evaluators when 'max_depth' is not configured
+	defaultMaxDepth = 32
+)
+
+// LeafStats holds the number of leaf values found under a JSON path and how
+// many of them are restricted. Partially masked values count as a fraction
+type LeafStats struct {
+	Restricted float64 `json:"restricted"`
+	Total      int     `json:"total"`
+}
+
+// LeafReport indexes the LeafStats of a document by JSON path. The positions
+// of the arrays are folded into "[*]", so every item of an array reports on
+// the same path. Example: "$.employees.employee[*].email"
+type LeafReport map[string]*LeafStats
+
+// Totals returns the restricted and total leaf values of the whole report
+func (r LeafReport) Totals() (float64, int) {
+	var restricted float64
+	var total int
+	for _, stats := range r {
+		restricted += stats.Restricted
+		total += stats.Total
+	}
+	return restricted, total
+}
+
+// InspectLeaves walks a JSON document and reports its restricted and total
+// leaf values per JSON path. A leaf is every value which is not an object or
+// an array: strings, numbers, booleans and nulls. Objects and arrays are not
+// counted, but walked. Strings containing a serialized JSON object or array
+// are decoded and walked as part of the document. Beyond the depth limit of
+// the EvaluationContext, the remaining nodes count as a single leaf
+func InspectLeaves(node interface{}, ec *EvaluationContext) LeafReport {
+	report := make(LeafReport)
+	walkLeaves(node, "$", 0, ec.maxDepth(), func(path string, value interface{}) {
+		stats, exists := report[path]
+		if !exists {
+			stats = &LeafStats{}
+			report[path] = stats
+		}
+		stats.Total++
+		if s, ok := value.(string); ok {
+			stats.Restricted += ec.Restriction(s)
+		}
+	})
+	return report
+}
+
+// maxDepth returns the nesting limit of the documents walked
+func (ec *EvaluationContext) maxDepth() int {
+	if ec.MaxDepth <= 0 {
+		return defaultMaxDepth
+	}
+	return ec.MaxDepth
+}
+
+// walkLeaves calls visit for every leaf value under a node with its JSON
+// path. 'depth' is the nesting level of the node, increased on every object,
+// array and embedded JSON document
+func walkLeaves(node interface{}, path string, depth int, maxDepth int, visit func(path string, value interface{})) {
+	switch n := node.(type) {
+	// if nested JSON, walk every key
+	case map[string]interface{}:
+		if depth >= maxDepth {
+			visit(path, encodeNode(n))
+			return
+		}
+		for key, value := range n {
+			walkLeaves(value, path+"."+key, depth+1, maxDepth, visit)
+		}
+	// if array, walk every item whatever its type
+	case []interface{}:
+		if depth >= maxDepth {
+			visit(path, encodeNode(n))
+			return
+		}
+		for _, value := range n {
+			walkLeaves(value, path+"[*]", depth+1, maxDepth, visit)
+		}
+	// if embedded JSON parse the value as a new JSON doc, and continue walking
+	case string:
+		if depth < maxDepth {
+			if embedded, ok := decodeEmbeddedJSON(n); ok {
+				walkLeaves(embedded, path, depth+1, maxDepth, visit)
+				return
+			}
+		}
+		visit(path, n)
+	default:
+		visit(path, n)
+	}
+}
+
+// decodeEmbeddedJSON decodes a string value containing a serialized JSON
+// object or array. Other JSON values are not decoded, so plain strings,
+// numbers and booleans stay as they are
+func decodeEmbeddedJSON(value string) (interface{}, bool) {
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return nil, false
+	}
+
+	var embedded interface{}
+	if err := json.Unmarshal([]byte(trimmed), &embedded); err != nil {
+		return nil, false
+	}
+	return embedded, true
+}
+
+// encodeNode serializes a node beyond the depth limit, so it can be evaluated
+// as a single string leaf
+func encodeNode(node interface{}) string {
+	encoded, err := json.Marshal(node)
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
@@ -0,0 +1,93 @@
+package apigator
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestInspectLeaves(t *testing.T) {
+	tests := []struct {
+		name     string
+		doc      string
+		maxDepth int
+		want     LeafReport
+	}{
+		{
+			name: "scalar leaves",
+			doc:  `{"a":"*****","b":1,"c":true,"d":null}`,
+			want: LeafReport{
+				"$.a": {Restricted: 1, Total: 1},
+				"$.b": {Total: 1},
+				"$.c": {Total: 1},
+				"$.d": {Total: 1},
+			},
+		},
+		{
+			name: "array positions folded",
+			doc:  `{"l":[{"e":"*****"},{"e":"x"},"y"]}`,
+			want: LeafReport{
+				"$.l[*].e": {Restricted: 1, Total: 2},
+				"$.l[*]":   {Total: 1},
+			},
+		},
+		{
+			name: "empty containers have no leaves",
+			doc:  `{"o":{},"l":[]}`,
+			want: LeafReport{},
+		},
+		{
+			name: "embedded JSON walked",
+			doc:  `{"p":"{\"name\":\"*****\",\"tags\":[\"x\"]}","s":"{not json"}`,
+			want: LeafReport{
+				"$.p.name":    {Restricted: 1, Total: 1},
+				"$.p.tags[*]": {Total: 1},
+				"$.s":         {Total: 1},
+			},
+		},
+		{
+			name:     "beyond the depth limit",
+			doc:      `{"a":{"b":{"c":"*****"}}}`,
+			maxDepth: 2,
+			want: LeafReport{
+				"$.a.b": {Restricted: 1, Total: 1},
+			},
+		},
+		{
+			name:     "embedded JSON beyond the depth limit",
+			doc:      `{"p":"{\"name\":\"x\"}"}`,
+			maxDepth: 1,
+			want: LeafReport{
+				"$.p": {Total: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc interface{}
+			if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+				t.Fatal(err)
+			}
+			ec := &EvaluationContext{RestrictedText: "*****", MaxDepth: tt.maxDepth}
+
+			got := InspectLeaves(doc, ec)
+			if !reflect.DeepEqual(got, tt.want) {
+				gotJSON, _ := json.Marshal(got)
+				wantJSON, _ := json.Marshal(tt.want)
+				t.Errorf("InspectLeaves = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestLeafReportTotals(t *testing.T) {
+	report := LeafReport{
+		"$.a": {Restricted: 0.5, Total: 1},
+		"$.b": {Restricted: 1, Total: 3},
+	}
+	restricted, total := report.Totals()
+	if restricted != 1.5 || total != 4 {
+		t.Errorf("Totals = %v, %v, want 1.5, 4", restricted, total)
+	}
+}
@@ -1,5 +1,8 @@
 package apigator
 
+// TokenResponse represents the response of the APIGator identity endpoint.
+// 'expires_in' is the lifetime of the token in seconds
 type TokenResponse struct {
 	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
 }
@@ -0,0 +1,348 @@
+// Package audit writes the tamper-evident record of every routing decision of
+// the APIGatorDoraRouter. Records are written as JSON lines, and each one
+// includes the hash of the previous record, so removing or modifying any
+// record breaks the chain
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMaxSize is the size in megabytes of the audit file before
+	// rotating it when 'max_size' is not configured
+	defaultMaxSize = 100
+	// rotatedTimeFormat is the suffix added to the rotated files
+	rotatedTimeFormat = "20060102T150405.000000000"
+)
+
+// Config defines where the audit records are written and for how long they
+// are kept. Configured on the "[audit]" INI section. Changes require a restart
+type Config struct {
+	// Path of the audit file. Auditing is disabled if it's empty
+	File string `ini:"file"`
+	// Size in megabytes of the audit file before rotating it
+	MaxSize int `ini:"max_size"`
+	// Number of rotated files kept. 0 keeps every file
+	MaxBackups int `ini:"max_backups"`
+	// Days a rotated file is kept. 0 keeps the files forever
+	MaxAge int `ini:"max_age"`
+	// HTTP header with the identity of the caller, set by the authenticating
+	// proxy in front of the router. The origin IP is always recorded
+	CallerHeader string `ini:"caller_header"`
+}
+
+// DefaultConfig returns the Config with the default values
+func DefaultConfig() Config {
+	return Config{MaxSize: defaultMaxSize}
+}
+
+// Validate checks the rotation and retention settings
+func (c Config) Validate() error {
+	if c.MaxSize <= 0 {
+		return fmt.Errorf("max_size must be greater than 0")
+	}
+	if c.MaxBackups < 0 {
+		return fmt.Errorf("max_backups can't be negative")
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("max_age can't be negative")
+	}
+	return nil
+}
+
+// TargetRecord is the outcome of the request forwarded to a target
+type TargetRecord struct {
+	Target         string   `json:"target"`
+	Status         string   `json:"status"`
+	StatusCode     int      `json:"status_code,omitempty"`
+	LatencyMS      int64    `json:"latency_ms,omitempty"`
+	Score          *float64 `json:"score,omitempty"`
+	DiscardReason  string   `json:"discard_reason,omitempty"`
+	ResponseSHA256 string   `json:"response_sha256,omitempty"`
+}
+
+// Record is a routing decision. Payloads are never recorded, only their hashes
+type Record struct {
+	Sequence              uint64         `json:"sequence"`
+	Timestamp             time.Time      `json:"timestamp"`
+	RequestID             string         `json:"request_id"`
+	Caller                string         `json:"caller,omitempty"`
+	CallerIP              string         `json:"caller_ip"`
+	CountryCode           string         `json:"country_code,omitempty"`
+	DataOwningCountryCode string         `json:"data_owning_country_code,omitempty"`
+	ManifestName          string         `json:"manifest_name,omitempty"`
+	DataUsageID           string         `json:"data_usage_id,omitempty"`
+	ScoreFunction         string         `json:"score_function"`
+	SelectionMode         string         `json:"selection_mode"`
+	Targets               []TargetRecord `json:"targets"`
+	SelectedTarget        string         `json:"selected_target,omitempty"`
+	RequestSHA256         string         `json:"request_sha256"`
+	ResponseSHA256        string         `json:"response_sha256,omitempty"`
+	PrevHash              string         `json:"prev_hash"`
+	Hash                  string         `json:"hash,omitempty"`
+}
+
+// Digest returns the SHA-256 of a payload in hexadecimal
+func Digest(payload []byte) string {
+	digest := sha256.Sum256(payload)
+	return hex.EncodeToString(digest[:])
+}
+
+// hash returns the hash of a record, computed over its JSON encoding
+// without the hash itself
+func (r Record) hash() (string, error) {
+	r.Hash = ""
+	encoded, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	return Digest(encoded), nil
+}
+
+// Logger writes the audit records to a file, rotating it when it reaches its
+// maximum size. It can be used concurrently. A nil Logger discards the records
+type Logger struct {
+	config Config
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	sequence uint64
+	lastHash string
+}
+
+// Open opens the audit file for appending records. If the file already has
+// records, the chain continues from the last one
+func Open(config Config) (*Logger, error) {
+	l := &Logger{config: config}
+	if err := l.resume(); err != nil {
+		return nil, err
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Write completes the record with its sequence number, timestamp and hashes,
+// appends it to the audit file and flushes it to the disk. The record is
+// durable once it returns without error
+func (l *Logger) Write(record Record) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	record.Sequence = l.sequence + 1
+	record.Timestamp = time.Now().UTC()
+	record.PrevHash = l.lastHash
+	hash, err := record.hash()
+	if err != nil {
+		return err
+	}
+	record.Hash = hash
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if l.size > 0 && l.size+int64(len(line)) > int64(l.config.MaxSize)*1024*1024 {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate audit file: %v", err)
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	// The record is already on the file, so the chain continues from it even
+	// if it can't be flushed
+	l.sequence = record.Sequence
+	l.lastHash = record.Hash
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to flush audit file: %v", err)
+	}
+	return nil
+}
+
+// Close closes the audit file
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
+
+// resume reads the last record of the audit file, if any, for continuing its
+// chain. If the audit file is empty or missing, the last rotated file is read
+func (l *Logger) resume() error {
+	rotated, err := RotatedFiles(l.config.File)
+	if err != nil {
+		return err
+	}
+	files := []string{l.config.File}
+	if len(rotated) > 0 {
+		files = append(files, rotated[len(rotated)-1])
+	}
+
+	for _, fileName := range files {
+		last, err := lastLine(fileName)
+		if err != nil {
+			return err
+		}
+		if last == nil {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(last, &record); err != nil {
+			return fmt.Errorf("failed to read last audit record: %v", err)
+		}
+		l.sequence = record.Sequence
+		l.lastHash = record.Hash
+		return nil
+	}
+	return nil
+}
+
+// lastLine returns the last non empty line of a file, or nil if the file
+// doesn't exist or is empty
+func lastLine(fileName string) ([]byte, error) {
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var last []byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit file: %v", err)
+	}
+	return last, nil
+}
+
+// open opens the audit file for appending. It must be called holding the lock
+func (l *Logger) open() error {
+	file, err := os.OpenFile(l.config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// rotate renames the audit file adding the current time, opens a new one and
+// removes the rotated files exceeding the retention settings. The chain
+// continues on the new file. It must be called holding the lock
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	rotated := l.config.File + "." + time.Now().UTC().Format(rotatedTimeFormat)
+	if err := os.Rename(l.config.File, rotated); err != nil {
+		return err
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	return l.cleanup()
+}
+
+// cleanup removes the oldest rotated files beyond 'max_backups' and the ones
+// older than 'max_age' days
+func (l *Logger) cleanup() error {
+	rotated, err := RotatedFiles(l.config.File)
+	if err != nil {
+		return err
+	}
+
+	for i, name := range rotated {
+		remove := l.config.MaxBackups > 0 && i < len(rotated)-l.config.MaxBackups
+		if !remove && l.config.MaxAge > 0 {
+			info, err := os.Stat(name)
+			remove = err == nil && time.Since(info.ModTime()) > time.Duration(l.config.MaxAge)*24*time.Hour
+		}
+		if remove {
+			if err := os.Remove(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RotatedFiles returns the rotated files of an audit file, from the oldest
+// to the newest
+func RotatedFiles(fileName string) ([]string, error) {
+	rotated, err := filepath.Glob(fileName + ".*")
+	if err != nil {
+		return nil, err
+	}
+	// The time suffix sorts in chronological order
+	sort.Strings(rotated)
+	return rotated, nil
+}
+
+// Verify checks the hash chain of the audit records read from 'r'. The first
+// record must follow 'prevHash', unless it's empty. It returns the hash of the
+// last record, for verifying the next file of the chain
+func Verify(r io.Reader, prevHash string) (string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return "", fmt.Errorf("line %d: invalid record: %v", line, err)
+		}
+		if prevHash != "" && record.PrevHash != prevHash {
+			return "", fmt.Errorf("line %d: record %d doesn't follow the previous record", line, record.Sequence)
+		}
+		hash, err := record.hash()
+		if err != nil {
+			return "", err
+		}
+		if hash != record.Hash {
+			return "", fmt.Errorf("line %d: record %d was modified", line, record.Sequence)
+		}
+		prevHash = record.Hash
+	}
+	return prevHash, scanner.Err()
+}
@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeRecords writes 'n' records with a new Logger on the audit file and
+// returns the lines of the file
+func writeRecords(t *testing.T, fileName string, n int) []string {
+	t.Helper()
+
+	logger, err := Open(Config{File: fileName, MaxSize: defaultMaxSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := logger.Write(Record{RequestID: "request", SelectedTarget: "target"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+func TestVerify(t *testing.T) {
+	lines := writeRecords(t, filepath.Join(t.TempDir(), "audit.log"), 4)
+
+	tests := []struct {
+		name    string
+		lines   []string
+		wantErr string
+	}{
+		{name: "valid chain", lines: lines},
+		{name: "empty"},
+		{name: "blank lines", lines: []string{lines[0], "", lines[1], " "}},
+		{
+			name:    "modified record",
+			lines:   []string{lines[0], strings.Replace(lines[1], `"selected_target":"target"`, `"selected_target":"other"`, 1)},
+			wantErr: "line 2: record 2 was modified",
+		},
+		{name: "removed record", lines: []string{lines[0], lines[2]}, wantErr: "line 2: record 3 doesn't follow the previous record"},
+		{name: "swapped records", lines: []string{lines[1], lines[0]}, wantErr: "line 2: record 1 doesn't follow the previous record"},
+		{name: "invalid record", lines: []string{lines[0], "{"}, wantErr: "line 2: invalid record"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Verify(strings.NewReader(strings.Join(tt.lines, "\n")), "")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Verify = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("Verify = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyAcrossFiles(t *testing.T) {
+	lines := writeRecords(t, filepath.Join(t.TempDir(), "audit.log"), 4)
+	first := strings.Join(lines[:2], "\n")
+	second := strings.Join(lines[2:], "\n")
+
+	last, err := Verify(strings.NewReader(first), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last == "" {
+		t.Fatal("Verify returned an empty hash")
+	}
+	if _, err := Verify(strings.NewReader(second), last); err != nil {
+		t.Errorf("Verify of the next file = %v, want nil", err)
+	}
+	if _, err := Verify(strings.NewReader(second), "unknown"); err == nil {
+		t.Error("Verify with the wrong previous hash succeeded")
+	}
+}
+
+func TestOpenResumesChain(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "audit.log")
+	writeRecords(t, fileName, 2)
+	lines := writeRecords(t, fileName, 2)
+
+	if len(lines) != 4 {
+		t.Fatalf("audit file has %d records, want 4", len(lines))
+	}
+	if !strings.Contains(lines[3], `"sequence":4`) {
+		t.Errorf("last record %s, want sequence 4", lines[3])
+	}
+	if _, err := Verify(strings.NewReader(strings.Join(lines, "\n")), ""); err != nil {
+		t.Errorf("Verify = %v, want nil", err)
+	}
+}
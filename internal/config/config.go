@@ -2,6 +2,8 @@ package config
 
 import (
 	ag "exate-dora-router/internal/apigator"
+	"exate-dora-router/internal/audit"
+	"exate-dora-router/internal/tracing"
 	"fmt"
 	"go.uber.org/zap"
 	ini "gopkg.in/ini.v1"
@@ -14,14 +16,79 @@ const (
 	iniAPIGatorPrefix = "api_gator"
 	iniRouterSection  = "router"
 	iniCommonSection  = "common"
+	// Settings shared by several targets are defined on sections named
+	// "group_<name>", inherited by the targets with "group = <name>"
+	iniGroupPrefix    = "group_"
+	iniSchemasSection = "schemas"
+	iniMaskingSection = "masking"
+	iniTracingSection = "tracing"
+	iniAuditSection   = "audit"
+	// Data-residency rules, and the regions (lists of country codes) they use
+	iniResidencySection = "residency"
+	iniRegionsSection   = "regions"
+	// Evaluator parameters are defined on sections named "evaluator.<name>"
+	iniEvaluatorPrefix = "evaluator."
+
+	// Evaluator used when 'score_function' is not configured
+	defaultScoreFuncName = "basic"
 )
 
-func LoadConfig(fileName string, logger *zap.Logger) (*ag.APIGatorRouter, error) {
-	cfg, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true}, fileName)
+// evaluatorParamsLoader returns the loader for reading the parameters of the
+// evaluators from their own INI section. Evaluators without a section keep
+// their default parameters
+func evaluatorParamsLoader(cfg *ini.File) ag.EvaluatorParamsLoader {
+	return func(name string, params interface{}) error {
+		section, err := cfg.GetSection(iniEvaluatorPrefix + name)
+		if err != nil {
+			return nil
+		}
+		if err := section.MapTo(params); err != nil {
+			return fmt.Errorf("failed to parse %s config: %v", section.Name(), err)
+		}
+		return nil
+	}
+}
+
+// seconds converts a number of seconds with fractions to a time.Duration
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
+// LoadConfig reads the configuration file (INI, YAML or JSON) and builds the
+// APIGatorRouter with its list of APIGatorTargets. The settings of the file
+// are overridden by the "DORA_ROUTER_*" environment variables and then by the
+// 'overrides'. The configuration is also checked as ValidateConfig does: if
+// 'strict' is true any problem found rejects it, otherwise they are only
+// logged as warnings
+func LoadConfig(fileName string, overrides Overrides, strict bool, logger *zap.Logger) (*ag.APIGatorRouter, error) {
+	cfg, err := readConfig(fileName, overrides)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %v", err)
+		return nil, err
+	}
+	router, err := buildRouter(cfg, logger, true)
+	if err != nil {
+		return nil, err
+	}
+	if errs := checkRouter(cfg, router); len(errs) > 0 {
+		messages := make([]string, len(errs))
+		for i, err := range errs {
+			messages[i] = err.Error()
+		}
+		if strict {
+			return nil, fmt.Errorf("invalid configuration: %s", strings.Join(messages, "; "))
+		}
+		logger.Warn("Configuration problems found. Run with -strict to reject them", zap.Strings("problems", messages))
 	}
 
+	logger.Info("Configuration Loaded Successfully", zap.Int("apigators_count", len(router.APIGatorTargets)))
+	return router, nil
+}
+
+// buildRouter builds the APIGatorRouter from the parsed INI configuration.
+// The secret references of the targets are only resolved if resolveSecrets
+// is true
+func buildRouter(cfg *ini.File, logger *zap.Logger, resolveSecrets bool) (*ag.APIGatorRouter, error) {
+	var err error
 	var commonConfig ag.APIGatorConfig
 	if err := cfg.Section(iniCommonSection).MapTo(&commonConfig); err != nil {
 		return nil, fmt.Errorf("failed to parse common config: %v", err)
@@ -30,40 +97,184 @@ func LoadConfig(fileName string, logger *zap.Logger) (*ag.APIGatorRouter, error)
 	var APIGators []*ag.APIGatorTarget
 	for _, section := range cfg.Sections() {
 		if strings.HasPrefix(section.Name(), iniAPIGatorPrefix) {
+			// Every target starts with the common config, overridden by its group
+			// section (if any) and then by its own section
 			var target ag.APIGatorTarget
-			if err := section.MapTo(&target); err != nil {
-				return nil, fmt.Errorf("failed to parse API Gator config: %v", err)
+			targetConfig := commonConfig
+			sections := []*ini.Section{section}
+			if group := section.Key("group").String(); group != "" {
+				groupSection, err := cfg.GetSection(iniGroupPrefix + group)
+				if err != nil {
+					return nil, fmt.Errorf("unknown group %q of API Gator section [%s]", group, section.Name())
+				}
+				sections = []*ini.Section{groupSection, section}
+			}
+			for _, source := range sections {
+				if err := source.MapTo(&target); err != nil {
+					return nil, fmt.Errorf("failed to parse API Gator config: %v", err)
+				}
+				if err := source.MapTo(&targetConfig); err != nil {
+					return nil, fmt.Errorf("failed to parse API Gator config: %v", err)
+				}
+			}
+			if target.Priority < 0 || target.Priority > 1 {
+				return nil, fmt.Errorf("priority of API Gator %q must be between 0.0 and 1.0", target.Name)
+			}
+			if targetConfig.BreakerFailures < 0 || targetConfig.BreakerWindow < 0 || targetConfig.BreakerCooldown < 0 {
+				return nil, fmt.Errorf("breaker settings of API Gator %q can't be negative", target.Name)
+			}
+			if targetConfig.BreakerErrorRate < 0 || targetConfig.BreakerErrorRate > 1 {
+				return nil, fmt.Errorf("breaker_error_rate of API Gator %q must be between 0.0 and 1.0", target.Name)
+			}
+			if targetConfig.RetryMaxAttempts < 0 || targetConfig.RetryBackoff < 0 || targetConfig.RetryMaxBackoff < 0 || targetConfig.RetryBudget < 0 {
+				return nil, fmt.Errorf("retry settings of API Gator %q can't be negative", target.Name)
+			}
+			for _, code := range targetConfig.RetryStatusCodes {
+				if code < 100 || code > 599 || code == http.StatusOK || code == http.StatusUnauthorized {
+					return nil, fmt.Errorf("invalid retry_status_codes %d of API Gator %q", code, target.Name)
+				}
 			}
-			target.Config = &commonConfig
+			if targetConfig.HedgePercentile < 0 || targetConfig.HedgePercentile >= 1 {
+				return nil, fmt.Errorf("hedge_percentile of API Gator %q must be between 0.0 and 1.0", target.Name)
+			}
+			if targetConfig.HedgeMinSamples < 0 {
+				return nil, fmt.Errorf("hedge_min_samples of API Gator %q can't be negative", target.Name)
+			}
+			if resolveSecrets {
+				if err := target.ResolveSecrets(); err != nil {
+					return nil, fmt.Errorf("failed to configure API Gator %q: %v", target.Name, err)
+				}
+			}
+			target.Config = &targetConfig
 			target.Client = &http.Client{
-				Timeout: commonConfig.Timeout * time.Second,
+				Timeout: targetConfig.Timeout * time.Second,
 			}
 			target.Logger = logger
+			target.Tokens = ag.NewTokenManager(&target, targetConfig.TokenRefreshAhead*time.Second)
+			target.Health = ag.NewHealthChecker(&target, targetConfig.HealthCheckInterval*time.Second, targetConfig.HealthCheckTimeout*time.Second)
+			target.Breaker = ag.NewCircuitBreaker(target.Name, targetConfig.BreakerFailures, targetConfig.BreakerErrorRate, targetConfig.BreakerWindow, targetConfig.BreakerCooldown*time.Second)
+			target.Latencies = map[string]*ag.LatencyTracker{}
+			for _, host := range append([]string{target.Host}, target.AlternateHosts...) {
+				target.Latencies[host] = ag.NewLatencyTracker(targetConfig.HedgeMinSamples)
+			}
+			target.Retry = ag.NewRetryPolicy(targetConfig.RetryMaxAttempts, targetConfig.RetryStatusCodes, targetConfig.RetryTransportErrors,
+				seconds(targetConfig.RetryBackoff), seconds(targetConfig.RetryMaxBackoff), seconds(targetConfig.RetryBudget))
 			APIGators = append(APIGators, &target)
 		}
 	}
 
-	var router ag.APIGatorRouter
+	// Settings not defined on the [router] section keep their default value
+	router := ag.APIGatorRouter{MinHealthyTargets: ag.DefaultMinHealthyTargets}
 	if err := cfg.Section(iniRouterSection).MapTo(&router); err != nil {
 		return nil, fmt.Errorf("failed to parse APIGatorRouter config: %v", err)
 	}
 	router.APIGatorTargets = APIGators
 
 	// Based on the score method configured in the INI config file, the router
-	// will be configured with the corresponding function for the choosen method
-	switch router.ScoreFuncName {
-	case "basic":
-		logger.Warn("Using Basic Response Evaluator")
-		router.ScoreFunc = ag.BasicEvaluator
-	case "percentage":
-		logger.Warn("Using Percentage Response Evaluator")
-		router.ScoreFunc = ag.PercentEvaluator
+	// will be configured with the evaluator registered under that name
+	if router.ScoreFuncName == "" {
+		logger.Warn("No score_function configured. Using Default Response Evaluator", zap.String("score_function", defaultScoreFuncName))
+		router.ScoreFuncName = defaultScoreFuncName
+	}
+	router.ScoreFunc, err = ag.NewEvaluator(router.ScoreFuncName, evaluatorParamsLoader(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure score_function: %v", err)
+	}
+	logger.Warn("Using Response Evaluator", zap.String("score_function", router.ScoreFuncName))
+
+	if router.MaxDepth < 0 {
+		return nil, fmt.Errorf("max_depth can't be negative")
+	}
+	if router.MinHealthyTargets < 0 {
+		return nil, fmt.Errorf("min_healthy_targets can't be negative")
+	}
+
+	// Requests not served by any target are broadcast unless configured otherwise
+	switch router.RoutingFallback {
+	case "":
+		router.RoutingFallback = ag.RoutingFallbackBroadcast
+	case ag.RoutingFallbackBroadcast, ag.RoutingFallbackReject:
 	default:
-		logger.Warn("Using Default Response Evaluator")
-		router.ScoreFunc = ag.BasicEvaluator
+		return nil, fmt.Errorf("unknown routing_fallback: %q", router.RoutingFallback)
 	}
 
-	logger.Info("Configuration Loaded Successfully", zap.Int("apigators_count", len(router.APIGatorTargets)))
+	// The selection mode defines if the router waits for every APIGatorTarget
+	// or returns the first response good enough
+	switch router.SelectionMode {
+	case "", ag.SelectionModeBest:
+		router.SelectionMode = ag.SelectionModeBest
+	case ag.SelectionModeRace:
+		if router.RaceScore <= 0 {
+			router.RaceScore = ag.DefaultRaceScore
+		}
+		logger.Warn("Using Race selection mode", zap.Float64("race_score", router.RaceScore))
+	case ag.SelectionModeMerge:
+		logger.Warn("Using Merge selection mode", zap.Bool("merge_provenance", router.MergeProvenance))
+	default:
+		return nil, fmt.Errorf("unknown selection_mode: %q", router.SelectionMode)
+	}
+
+	// JSON Schemas for validating the responses are defined on the [schemas]
+	// section as "<manifestName> = <schema file>"
+	if schemasSection, err := cfg.GetSection(iniSchemasSection); err == nil {
+		if router.SchemaMode == "" {
+			router.SchemaMode = ag.SchemaModeDisqualify
+		}
+		router.Schemas, err = ag.NewSchemaRegistry(schemasSection.KeysHash(), router.SchemaMode, router.SchemaPenalty)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load JSON Schemas: %v", err)
+		}
+		logger.Info("JSON Schemas loaded", zap.Int("schemas_count", len(schemasSection.Keys())), zap.String("schema_mode", router.SchemaMode))
+	}
+
+	// Mask patterns for recognising restricted values besides the restrictedText
+	if maskingSection, err := cfg.GetSection(iniMaskingSection); err == nil {
+		maskingConfig := ag.DefaultMaskingConfig()
+		if err := maskingSection.MapTo(&maskingConfig); err != nil {
+			return nil, fmt.Errorf("failed to parse masking config: %v", err)
+		}
+		router.Masking, err = ag.NewMaskMatcher(maskingConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure masking: %v", err)
+		}
+		logger.Info("Mask patterns loaded", zap.String("mask_characters", maskingConfig.MaskCharacters), zap.Int("patterns_count", len(maskingConfig.Patterns)))
+	}
+
+	// Data-residency guardrail. Every target is permitted without a [residency] section
+	if residencySection, err := cfg.GetSection(iniResidencySection); err == nil {
+		var regions map[string]string
+		if regionsSection, err := cfg.GetSection(iniRegionsSection); err == nil {
+			regions = regionsSection.KeysHash()
+		}
+		router.Residency, err = ag.NewResidencyPolicy(regions, residencySection.KeysHash())
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure residency: %v", err)
+		}
+		logger.Info("Data-residency rules loaded", zap.Int("rules_count", len(residencySection.Keys())), zap.Int("regions_count", len(regions)))
+	}
+
+	// Exporter of the tracing spans. Tracing is disabled without a [tracing] section
+	router.Tracing = tracing.DefaultConfig()
+	if tracingSection, err := cfg.GetSection(iniTracingSection); err == nil {
+		if err := tracingSection.MapTo(&router.Tracing); err != nil {
+			return nil, fmt.Errorf("failed to parse tracing config: %v", err)
+		}
+		if err := router.Tracing.Validate(); err != nil {
+			return nil, fmt.Errorf("failed to configure tracing: %v", err)
+		}
+	}
+
+	// Audit file of the routing decisions. Auditing is disabled without an
+	// [audit] section
+	router.Audit = audit.DefaultConfig()
+	if auditSection, err := cfg.GetSection(iniAuditSection); err == nil {
+		if err := auditSection.MapTo(&router.Audit); err != nil {
+			return nil, fmt.Errorf("failed to parse audit config: %v", err)
+		}
+		if err := router.Audit.Validate(); err != nil {
+			return nil, fmt.Errorf("failed to configure audit: %v", err)
+		}
+	}
 
 	return &router, nil
 }
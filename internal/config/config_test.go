@@ -0,0 +1,98 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestLoadConfigGroups(t *testing.T) {
+	const config = `[router]
+score_function = percentage
+
+[common]
+dataset_path = /common/dataset
+auth_path = /common/token
+timeout = 30
+grant_type = client_credentials
+health_check_interval = -1
+
+[group_eu]
+auth_path = /eu/token
+grant_type = password
+client_id = eu-client
+priority = 0.5
+
+[api_gator_germany]
+name = GERMANY
+group = eu
+host = https://de.example
+client_secret = secret
+api_key = key
+grant_type = refresh_token
+priority = 0.9
+
+[api_gator_france]
+name = FRANCE
+group = eu
+host = https://fr.example
+client_secret = secret
+api_key = key
+
+[api_gator_uk]
+name = UK
+host = https://uk.example
+client_id = uk-client
+client_secret = secret
+api_key = key
+`
+
+	fileName := filepath.Join(t.TempDir(), "config.ini")
+	writeFile(t, fileName, config)
+	router, err := LoadConfig(fileName, nil, true, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Target settings override the ones of the group, which override [common]
+	tests := []struct {
+		target      string
+		datasetPath string
+		authPath    string
+		grantType   string
+		clientID    string
+		priority    float64
+	}{
+		{target: "GERMANY", datasetPath: "/common/dataset", authPath: "/eu/token", grantType: "refresh_token", clientID: "eu-client", priority: 0.9},
+		{target: "FRANCE", datasetPath: "/common/dataset", authPath: "/eu/token", grantType: "password", clientID: "eu-client", priority: 0.5},
+		{target: "UK", datasetPath: "/common/dataset", authPath: "/common/token", grantType: "client_credentials", clientID: "uk-client"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			for _, target := range router.APIGatorTargets {
+				if target.Name != tt.target {
+					continue
+				}
+				got := target.Config
+				if got.DatasetPath != tt.datasetPath || got.AuthPath != tt.authPath || got.GrantType != tt.grantType {
+					t.Errorf("config = %+v, want dataset_path %q, auth_path %q and grant_type %q", got, tt.datasetPath, tt.authPath, tt.grantType)
+				}
+				if target.ClientID != tt.clientID || target.Priority != tt.priority {
+					t.Errorf("client_id %q and priority %v, want %q and %v", target.ClientID, target.Priority, tt.clientID, tt.priority)
+				}
+				return
+			}
+			t.Fatalf("target %s not loaded", tt.target)
+		})
+	}
+}
+
+func TestLoadConfigUnknownGroup(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "config.ini")
+	writeFile(t, fileName, testConfig("ALPHA")+"group = us\n")
+	if _, err := LoadConfig(fileName, nil, false, zap.NewNop()); err == nil {
+		t.Error("LoadConfig() accepted a target with an unknown group")
+	}
+}
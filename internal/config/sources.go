@@ -0,0 +1,276 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	ini "gopkg.in/ini.v1"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Environment variables overriding a setting are named
+	// "DORA_ROUTER_<SECTION>__<KEY>". Example: DORA_ROUTER_ROUTER__SCORE_FUNCTION
+	envOverridePrefix    = "DORA_ROUTER_"
+	envOverrideSeparator = "__"
+
+	// Keys of the structured (YAML/JSON) configuration file holding the list
+	// of APIGator targets, the target groups and the parameters of the
+	// evaluators by name
+	structuredTargetsKey    = "targets"
+	structuredGroupsKey     = "groups"
+	structuredEvaluatorsKey = "evaluators"
+)
+
+// Overrides is a list of "section.key=value" settings replacing the ones of
+// the configuration file. It implements flag.Value, so it can be filled by a
+// repeatable command-line flag. Example: "evaluator.composite.weights=0.5,0.5"
+type Overrides []string
+
+// String returns the overrides as a comma separated list
+func (o *Overrides) String() string {
+	return strings.Join(*o, ", ")
+}
+
+// Set adds an override after checking its format
+func (o *Overrides) Set(value string) error {
+	if _, _, _, err := parseOverride(value); err != nil {
+		return err
+	}
+	*o = append(*o, value)
+	return nil
+}
+
+// parseOverride splits a "section.key=value" override. The key is taken from
+// the last dot, so section names can contain dots
+func parseOverride(override string) (string, string, string, error) {
+	setting, value, found := strings.Cut(override, "=")
+	dot := strings.LastIndex(setting, ".")
+	if !found || dot <= 0 || dot == len(setting)-1 {
+		return "", "", "", fmt.Errorf("invalid override %q, expected section.key=value", override)
+	}
+	return setting[:dot], setting[dot+1:], value, nil
+}
+
+// readConfig reads the configuration file and applies on top of it the
+// environment variable overrides and then the 'overrides'
+func readConfig(fileName string, overrides Overrides) (*ini.File, error) {
+	cfg, err := readConfigFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	applyEnvOverrides(cfg, os.Environ())
+	for _, override := range overrides {
+		section, key, value, err := parseOverride(override)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Section(section).Key(key).SetValue(value)
+	}
+	return cfg, nil
+}
+
+// readConfigFile parses the configuration file. Files with a ".yaml", ".yml"
+// or ".json" extension are structured files, converted to the INI sections
+// they're equivalent to. Any other file is an INI file
+func readConfigFile(fileName string) (*ini.File, error) {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml", ".json":
+		content, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %v", err)
+		}
+		var doc map[string]interface{}
+		if strings.EqualFold(filepath.Ext(fileName), ".json") {
+			err = json.Unmarshal(content, &doc)
+		} else {
+			err = yaml.Unmarshal(content, &doc)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %v", err)
+		}
+		cfg, err := structuredToINI(doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %v", err)
+		}
+		return cfg, nil
+	}
+
+	cfg, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true}, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	return cfg, nil
+}
+
+// structuredToINI converts a structured configuration document to INI
+// sections. Every top level object is a section, except:
+//   - "targets": list of APIGator targets, each one converted to an
+//     "api_gator_<name>" section
+//   - "groups": settings shared by several targets by group name, each one
+//     converted to a "group_<name>" section
+//   - "evaluators": parameters of the evaluators by name, each one converted
+//     to an "evaluator.<name>" section
+func structuredToINI(doc map[string]interface{}) (*ini.File, error) {
+	cfg := ini.Empty(ini.LoadOptions{IgnoreInlineComment: true})
+
+	for _, name := range sortedKeys(doc) {
+		switch name {
+		case structuredTargetsKey:
+			targets, ok := doc[name].([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%q must be a list", name)
+			}
+			for i, item := range targets {
+				target, ok := item.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("%q item %d must be an object", name, i)
+				}
+				sectionName := fmt.Sprintf("%s_%d", iniAPIGatorPrefix, i)
+				if targetName, ok := target["name"].(string); ok && targetName != "" {
+					sectionName = iniAPIGatorPrefix + "_" + normalizeSectionName(targetName)
+				}
+				if _, err := cfg.GetSection(sectionName); err == nil {
+					return nil, fmt.Errorf("duplicated API Gator name %q", target["name"])
+				}
+				if err := addSection(cfg, sectionName, target); err != nil {
+					return nil, err
+				}
+			}
+		case structuredGroupsKey, structuredEvaluatorsKey:
+			prefix := iniGroupPrefix
+			if name == structuredEvaluatorsKey {
+				prefix = iniEvaluatorPrefix
+			}
+			objects, ok := doc[name].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%q must be an object", name)
+			}
+			for _, objectName := range sortedKeys(objects) {
+				values, ok := objects[objectName].(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("%q item %q must be an object", name, objectName)
+				}
+				if err := addSection(cfg, prefix+objectName, values); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			values, ok := doc[name].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%q must be an object", name)
+			}
+			if err := addSection(cfg, name, values); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// addSection adds a section to the INI file with the values of an object.
+// Lists are converted to comma separated values
+func addSection(cfg *ini.File, name string, values map[string]interface{}) error {
+	section, err := cfg.NewSection(name)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range sortedKeys(values) {
+		var value string
+		switch v := values[key].(type) {
+		case nil:
+		case map[string]interface{}:
+			return fmt.Errorf("[%s]: %q can't be an object", name, key)
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = scalarString(item)
+			}
+			value = strings.Join(items, ",")
+		default:
+			value = scalarString(v)
+		}
+		if _, err := section.NewKey(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scalarString formats a scalar value as an INI value. JSON numbers are always
+// decoded as float64, so they're formatted without exponent
+func scalarString(value interface{}) string {
+	if f, ok := value.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// applyEnvOverrides sets the values of the "DORA_ROUTER_<SECTION>__<KEY>"
+// environment variables. Section and key names are case insensitive, and the
+// dots and dashes of the section names are written as underscores, so
+// DORA_ROUTER_EVALUATOR_COMPOSITE__WEIGHTS sets [evaluator.composite] weights
+func applyEnvOverrides(cfg *ini.File, environ []string) {
+	for _, variable := range environ {
+		name, value, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(name, envOverridePrefix) {
+			continue
+		}
+		section, key, found := strings.Cut(strings.TrimPrefix(name, envOverridePrefix), envOverrideSeparator)
+		if !found || section == "" || key == "" {
+			continue
+		}
+		cfg.Section(envSectionName(cfg, section)).Key(strings.ToLower(key)).SetValue(value)
+	}
+}
+
+// envSectionName returns the section of the INI file matching the section
+// name of an environment variable. Unknown sections are created with the
+// lowercase name, restoring the dot of the evaluator sections
+func envSectionName(cfg *ini.File, envSection string) string {
+	normalized := normalizeSectionName(envSection)
+	for _, section := range cfg.Sections() {
+		if normalizeSectionName(section.Name()) == normalized {
+			return section.Name()
+		}
+	}
+
+	evaluatorPrefix := normalizeSectionName(iniEvaluatorPrefix)
+	if strings.HasPrefix(normalized, evaluatorPrefix) {
+		return iniEvaluatorPrefix + strings.TrimPrefix(normalized, evaluatorPrefix)
+	}
+	return normalized
+}
+
+// normalizeSectionName lowercases a section name and replaces every character
+// not valid on environment variable names with an underscore
+func normalizeSectionName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// sortedKeys returns the keys of an object sorted, so the sections and keys
+// are always generated in the same order
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
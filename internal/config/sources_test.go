@@ -0,0 +1,266 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	ini "gopkg.in/ini.v1"
+)
+
+// iniValues returns the keys of every non-empty section of an INI file
+func iniValues(cfg *ini.File) map[string]map[string]string {
+	values := make(map[string]map[string]string)
+	for _, section := range cfg.Sections() {
+		if len(section.Keys()) > 0 {
+			values[section.Name()] = section.KeysHash()
+		}
+	}
+	return values
+}
+
+func TestParseOverride(t *testing.T) {
+	tests := []struct {
+		override string
+		want     []string
+		wantErr  bool
+	}{
+		{override: "router.score_function=percentage", want: []string{"router", "score_function", "percentage"}},
+		{override: "evaluator.composite.weights=0.5,0.5", want: []string{"evaluator.composite", "weights", "0.5,0.5"}},
+		{override: "router.path=/forward?a=b", want: []string{"router", "path", "/forward?a=b"}},
+		{override: "common.timeout=", want: []string{"common", "timeout", ""}},
+		{override: "router.score_function", wantErr: true},
+		{override: "score_function=percentage", wantErr: true},
+		{override: ".score_function=percentage", wantErr: true},
+		{override: "router.=percentage", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.override, func(t *testing.T) {
+			section, key, value, err := parseOverride(tt.override)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOverride() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := []string{section, key, value}; !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseOverride() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadConfigFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		content  string
+		want     map[string]map[string]string
+		wantErr  bool
+	}{
+		{
+			name:     "YAML numbers",
+			fileName: "config.yaml",
+			content:  "common:\n  timeout: 30\n  retry_backoff: 0.25\n  max_size: 100000000\n  huge: 1e21\n  hedge_percentile: 0.95\n",
+			want:     map[string]map[string]string{"common": {"timeout": "30", "retry_backoff": "0.25", "max_size": "100000000", "huge": "1000000000000000000000", "hedge_percentile": "0.95"}},
+		},
+		{
+			name:     "JSON numbers",
+			fileName: "config.json",
+			content:  `{"common": {"timeout": 30, "retry_backoff": 0.25, "max_size": 100000000, "huge": 1e21, "hedge_percentile": 0.95}}`,
+			want:     map[string]map[string]string{"common": {"timeout": "30", "retry_backoff": "0.25", "max_size": "100000000", "huge": "1000000000000000000000", "hedge_percentile": "0.95"}},
+		},
+		{
+			name:     "YAML lists, booleans and nulls",
+			fileName: "config.yml",
+			content:  "router:\n  decision_headers: true\n  path: null\nevaluators:\n  composite:\n    weights: [0.5, 1, 0.25]\n",
+			want: map[string]map[string]string{
+				"router":              {"decision_headers": "true", "path": ""},
+				"evaluator.composite": {"weights": "0.5,1,0.25"},
+			},
+		},
+		{
+			name:     "targets and groups",
+			fileName: "config.yaml",
+			content:  "groups:\n  eu:\n    timeout: 10\ntargets:\n  - name: UK-1\n    host: https://uk.example\n  - name: Germany\n    group: eu\n  - host: https://anonymous.example\n",
+			want: map[string]map[string]string{
+				"group_eu":          {"timeout": "10"},
+				"api_gator_uk_1":    {"name": "UK-1", "host": "https://uk.example"},
+				"api_gator_germany": {"name": "Germany", "group": "eu"},
+				"api_gator_2":       {"host": "https://anonymous.example"},
+			},
+		},
+		{
+			name:     "duplicate target names",
+			fileName: "config.json",
+			content:  `{"targets": [{"name": "UK"}, {"name": "uk"}]}`,
+			wantErr:  true,
+		},
+		{
+			name:     "target names equal once normalised",
+			fileName: "config.yaml",
+			content:  "targets:\n  - name: UK-1\n  - name: uk_1\n",
+			wantErr:  true,
+		},
+		{
+			name:     "targets not a list",
+			fileName: "config.yaml",
+			content:  "targets:\n  name: UK\n",
+			wantErr:  true,
+		},
+		{
+			name:     "target not an object",
+			fileName: "config.yaml",
+			content:  "targets:\n  - UK\n",
+			wantErr:  true,
+		},
+		{
+			name:     "group not an object",
+			fileName: "config.yaml",
+			content:  "groups:\n  eu: 10\n",
+			wantErr:  true,
+		},
+		{
+			name:     "section not an object",
+			fileName: "config.json",
+			content:  `{"router": "percentage"}`,
+			wantErr:  true,
+		},
+		{
+			name:     "nested object",
+			fileName: "config.yaml",
+			content:  "router:\n  score:\n    function: percentage\n",
+			wantErr:  true,
+		},
+		{
+			name:     "invalid JSON",
+			fileName: "config.json",
+			content:  `{"router": `,
+			wantErr:  true,
+		},
+		{
+			name:     "INI",
+			fileName: "config.ini",
+			content:  "[router]\nscore_function = percentage ; not a comment\n",
+			want:     map[string]map[string]string{"router": {"score_function": "percentage ; not a comment"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), tt.fileName)
+			writeFile(t, fileName, tt.content)
+
+			cfg, err := readConfigFile(fileName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readConfigFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(iniValues(cfg), tt.want) {
+				t.Errorf("readConfigFile() = %v, want %v", iniValues(cfg), tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	const config = `[router]
+score_function = percentage
+
+[api_gator_UK-1]
+name = UK-1
+
+[evaluator.composite]
+weights = 0.5,0.5
+`
+
+	tests := []struct {
+		name    string
+		environ []string
+		want    map[string]map[string]string
+	}{
+		{
+			name:    "existing key",
+			environ: []string{"DORA_ROUTER_ROUTER__SCORE_FUNCTION=composite"},
+			want:    map[string]map[string]string{"router": {"score_function": "composite"}},
+		},
+		{
+			name:    "names are case insensitive",
+			environ: []string{"DORA_ROUTER_Router__Decision_Headers=true"},
+			want:    map[string]map[string]string{"router": {"score_function": "percentage", "decision_headers": "true"}},
+		},
+		{
+			name:    "target section with a dash",
+			environ: []string{"DORA_ROUTER_API_GATOR_UK_1__TIMEOUT=10"},
+			want:    map[string]map[string]string{"api_gator_UK-1": {"name": "UK-1", "timeout": "10"}},
+		},
+		{
+			name:    "existing evaluator section",
+			environ: []string{"DORA_ROUTER_EVALUATOR_COMPOSITE__WEIGHTS=0.25,0.75"},
+			want:    map[string]map[string]string{"evaluator.composite": {"weights": "0.25,0.75"}},
+		},
+		{
+			name:    "new evaluator section",
+			environ: []string{"DORA_ROUTER_EVALUATOR_REQUIRED_FIELDS__FIELDS=email"},
+			want:    map[string]map[string]string{"evaluator.required_fields": {"fields": "email"}},
+		},
+		{
+			name:    "new section",
+			environ: []string{"DORA_ROUTER_COMMON__TIMEOUT=5"},
+			want:    map[string]map[string]string{"common": {"timeout": "5"}},
+		},
+		{
+			name:    "empty value",
+			environ: []string{"DORA_ROUTER_ROUTER__SCORE_FUNCTION="},
+			want:    map[string]map[string]string{"router": {"score_function": ""}},
+		},
+		{
+			name: "ignored variables",
+			environ: []string{
+				"PATH=/usr/bin",
+				"ROUTER__SCORE_FUNCTION=composite",
+				"DORA_ROUTER_SCORE_FUNCTION=composite",
+				"DORA_ROUTER___SCORE_FUNCTION=composite",
+				"DORA_ROUTER_ROUTER__=composite",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ini.Load([]byte(config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := iniValues(cfg)
+			for section, keys := range tt.want {
+				want[section] = keys
+			}
+
+			applyEnvOverrides(cfg, tt.environ)
+			if got := iniValues(cfg); !reflect.DeepEqual(got, want) {
+				t.Errorf("applyEnvOverrides() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestReadConfigPrecedence(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile(t, fileName, "router:\n  path: /file\n  score_function: file\n  timeout: 1\n")
+	t.Setenv("DORA_ROUTER_ROUTER__SCORE_FUNCTION", "env")
+	t.Setenv("DORA_ROUTER_ROUTER__TIMEOUT", "2")
+
+	cfg, err := readConfig(fileName, Overrides{"router.timeout=3", "common.timeout=4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]string{
+		"router": {"path": "/file", "score_function": "env", "timeout": "3"},
+		"common": {"timeout": "4"},
+	}
+	if got := iniValues(cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("readConfig() = %v, want %v", got, want)
+	}
+
+	if _, err := readConfig(fileName, Overrides{"timeout=3"}); err == nil {
+		t.Error("readConfig() accepted an invalid override")
+	}
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	ag "exate-dora-router/internal/apigator"
+	"exate-dora-router/internal/audit"
+	"exate-dora-router/internal/tracing"
+	"fmt"
+	"go.uber.org/zap"
+	ini "gopkg.in/ini.v1"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// validSchemes are the schemes supported on the target host URLs
+var validSchemes = map[string]bool{
+	"http":  true,
+	"https": true,
+}
+
+// ValidateConfig performs a strict validation of the configuration file with
+// its overrides, the same LoadConfig does, returning every problem found.
+// Secret references are only resolved if resolveSecrets is true
+func ValidateConfig(fileName string, overrides Overrides, resolveSecrets bool) []error {
+	cfg, err := readConfig(fileName, overrides)
+	if err != nil {
+		return []error{err}
+	}
+
+	router, err := buildRouter(cfg, zap.NewNop(), resolveSecrets)
+	if err != nil {
+		return []error{err}
+	}
+	return checkRouter(cfg, router)
+}
+
+// checkRouter performs the strict checks of a configuration already built:
+// duplicated target names, wrong target hosts and ports, missing
+// credentials, a zero timeout and unknown sections or keys
+func checkRouter(cfg *ini.File, router *ag.APIGatorRouter) []error {
+	var errs []error
+	errs = append(errs, validateSections(cfg)...)
+
+	if len(router.APIGatorTargets) == 0 {
+		errs = append(errs, fmt.Errorf("no [%s*] sections defined", iniAPIGatorPrefix))
+	}
+	names := make(map[string]bool)
+	for _, target := range router.APIGatorTargets {
+		if names[target.Name] {
+			errs = append(errs, fmt.Errorf("duplicated API Gator name %q", target.Name))
+		}
+		names[target.Name] = true
+		errs = append(errs, validateTarget(target)...)
+		if router.Residency != nil && target.Location == "" {
+			errs = append(errs, fmt.Errorf("API Gator %q: missing location, needed by the [%s] rules", target.Name, iniResidencySection))
+		}
+	}
+
+	return errs
+}
+
+// validateTarget checks the hosts, alternate hosts, port, credentials and timeout of an APIGatorTarget
+func validateTarget(target *ag.APIGatorTarget) []error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("API Gator %q: %s", target.Name, fmt.Sprintf(format, args...)))
+	}
+
+	if target.Name == "" {
+		fail("missing name")
+	}
+	if target.ClientID == "" {
+		fail("missing client_id")
+	}
+	if target.ClientSecret == "" {
+		fail("missing client_secret")
+	}
+	if target.ApiKey == "" {
+		fail("missing api_key")
+	}
+	if target.Config.Timeout <= 0 {
+		fail("timeout must be greater than 0")
+	}
+
+	hostURL, err := url.Parse(target.Host)
+	if err != nil || !validSchemes[hostURL.Scheme] || hostURL.Host == "" {
+		fail("host %q must be an URL with http or https scheme", target.Host)
+	} else if hostURL.Port() != "" && target.Port != 0 && strconv.Itoa(target.Port) != hostURL.Port() {
+		// The port is added to hosts without an explicit one
+		fail("port %d conflicts with host %q", target.Port, target.Host)
+	}
+
+	for _, host := range target.AlternateHosts {
+		alternateURL, err := url.Parse(host)
+		if err != nil || !validSchemes[alternateURL.Scheme] || alternateURL.Host == "" {
+			fail("alternate host %q must be an URL with http or https scheme", host)
+		}
+	}
+	if target.Config.HedgePercentile > 0 && len(target.AlternateHosts) == 0 {
+		fail("hedge_percentile needs alternate_hosts")
+	}
+
+	if target.Config.AuthHost != "" {
+		authURL, err := url.Parse(target.Config.AuthHost)
+		if err != nil || !validSchemes[authURL.Scheme] || authURL.Host == "" {
+			fail("auth_host %q must be an URL with http or https scheme", target.Config.AuthHost)
+		}
+	}
+
+	return errs
+}
+
+// validateSections checks that every section and key of the INI file is known
+func validateSections(cfg *ini.File) []error {
+	var errs []error
+	for _, section := range cfg.Sections() {
+		name := section.Name()
+
+		var known map[string]bool
+		switch {
+		case name == ini.DefaultSection:
+			if len(section.Keys()) > 0 {
+				errs = append(errs, fmt.Errorf("keys defined outside of any section: %s", strings.Join(section.KeyStrings(), ", ")))
+			}
+			continue
+		case name == iniRouterSection:
+			known = iniKeys(&ag.APIGatorRouter{})
+		case name == iniCommonSection:
+			known = iniKeys(&ag.APIGatorConfig{})
+		case name == iniMaskingSection:
+			known = iniKeys(&ag.MaskingConfig{})
+		case name == iniTracingSection:
+			known = iniKeys(&tracing.Config{})
+		case name == iniAuditSection:
+			known = iniKeys(&audit.Config{})
+		case name == iniSchemasSection:
+			// Keys are manifest names
+			continue
+		case name == iniResidencySection, name == iniRegionsSection:
+			// Keys are country codes and region names
+			continue
+		case strings.HasPrefix(name, iniAPIGatorPrefix), strings.HasPrefix(name, iniGroupPrefix):
+			// Targets and groups can override every common setting
+			known = iniKeys(&ag.APIGatorTarget{})
+			for key := range iniKeys(&ag.APIGatorConfig{}) {
+				known[key] = true
+			}
+		case strings.HasPrefix(name, iniEvaluatorPrefix):
+			params, exists := ag.EvaluatorParams(strings.TrimPrefix(name, iniEvaluatorPrefix))
+			if !exists {
+				errs = append(errs, fmt.Errorf("[%s]: unknown evaluator", name))
+				continue
+			}
+			known = iniKeys(params)
+		default:
+			errs = append(errs, fmt.Errorf("unknown section [%s]", name))
+			continue
+		}
+
+		for _, key := range section.KeyStrings() {
+			if !known[key] {
+				errs = append(errs, fmt.Errorf("[%s]: unknown key %q", name, key))
+			}
+		}
+	}
+	return errs
+}
+
+// iniKeys returns the INI keys mapped by the 'ini' tags of a struct
+func iniKeys(v interface{}) map[string]bool {
+	keys := make(map[string]bool)
+	t := reflect.TypeOf(v).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("ini"), ",")[0]
+		if tag != "" && tag != "-" {
+			keys[tag] = true
+		}
+	}
+	return keys
+}
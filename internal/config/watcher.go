@@ -0,0 +1,144 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	ag "exate-dora-router/internal/apigator"
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultReloadInterval is the time between checks of the configuration
+	// file when 'reload_interval' is not configured
+	defaultReloadInterval = 10 * time.Second
+	// reloadDebounce is how long a changed configuration file must stay
+	// unchanged before loading it, so a file still being written is not loaded
+	reloadDebounce = time.Second
+)
+
+// Watcher reloads the configuration file when it changes or when the process
+// receives a SIGHUP signal. Every new configuration is loaded with LoadConfig,
+// applying the same overrides and strictness, and handed over to the apply
+// function, which can still reject it. The running configuration is untouched
+// if the new one fails
+type Watcher struct {
+	fileName  string
+	overrides Overrides
+	strict    bool
+	logger    *zap.Logger
+	apply     func(router *ag.APIGatorRouter) error
+	debounce  time.Duration
+
+	mu   sync.Mutex
+	hash [sha256.Size]byte
+}
+
+// NewWatcher returns a Watcher for the configuration file, which was already
+// loaded and is currently in use
+func NewWatcher(fileName string, overrides Overrides, strict bool, logger *zap.Logger, apply func(router *ag.APIGatorRouter) error) *Watcher {
+	w := &Watcher{fileName: fileName, overrides: overrides, strict: strict, logger: logger, apply: apply, debounce: reloadDebounce}
+	if content, err := os.ReadFile(fileName); err == nil {
+		w.hash = sha256.Sum256(content)
+	}
+	return w
+}
+
+// Run checks the configuration file every 'interval' and listens for SIGHUP
+// signals until the context is done. 0 means the default interval, and a
+// negative value disables the file checks, so only SIGHUP triggers reloads
+func (w *Watcher) Run(ctx context.Context, interval time.Duration) {
+	if interval == 0 {
+		interval = defaultReloadInterval
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	var ticks <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			w.logger.Info("SIGHUP received. Reloading configuration", zap.String("config_file", w.fileName))
+			_ = w.Reload(true)
+		case <-ticks:
+			_ = w.Reload(false)
+		}
+	}
+}
+
+// Reload loads the configuration file and applies it. Unless 'force' is
+// true, the file is only loaded if its content changed since the last reload,
+// once it stays unchanged for the debounce time
+func (w *Watcher) Reload(force bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	hash, err := w.readHash()
+	if err != nil {
+		return err
+	}
+	if !force {
+		if hash == w.hash {
+			return nil
+		}
+		if hash, err = w.settle(hash); err != nil {
+			return err
+		}
+	}
+	// The content is marked as seen even if it's rejected, so a wrong file is
+	// reported only once
+	w.hash = hash
+
+	router, err := LoadConfig(w.fileName, w.overrides, w.strict, w.logger)
+	if err == nil {
+		err = w.apply(router)
+	}
+	if err != nil {
+		err = fmt.Errorf("configuration reload rejected: %v", err)
+		w.logger.Error("Keeping running configuration", zap.String("config_file", w.fileName), zap.Error(err))
+		return err
+	}
+
+	w.logger.Info("Configuration reloaded", zap.String("config_file", w.fileName))
+	return nil
+}
+
+// settle waits until the configuration file keeps the same content for the
+// debounce time, and returns the hash of that content. 'hash' is the hash of
+// the content last read
+func (w *Watcher) settle(hash [sha256.Size]byte) ([sha256.Size]byte, error) {
+	for {
+		time.Sleep(w.debounce)
+		next, err := w.readHash()
+		if err != nil || next == hash {
+			return next, err
+		}
+		w.logger.Debug("Config file still changing. Waiting before reloading it", zap.String("config_file", w.fileName))
+		hash = next
+	}
+}
+
+// readHash returns the hash of the content of the configuration file
+func (w *Watcher) readHash() ([sha256.Size]byte, error) {
+	content, err := os.ReadFile(w.fileName)
+	if err != nil {
+		w.logger.Error("Can't read config file for reloading it", zap.String("config_file", w.fileName), zap.Error(err))
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(content), nil
+}
@@ -0,0 +1,192 @@
+package config
+
+import (
+	"context"
+	"errors"
+	ag "exate-dora-router/internal/apigator"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// testConfig returns a valid INI configuration with a single target
+func testConfig(targetName string) string {
+	return `[router]
+score_function = percentage
+
+[common]
+timeout = 30
+
+[api_gator_alpha]
+name = ` + targetName + `
+host = https://alpha.example
+client_id = client
+client_secret = secret
+api_key = key
+`
+}
+
+// writeFile writes the content of a test file, failing the test on error
+func writeFile(t *testing.T, fileName string, content string) {
+	t.Helper()
+	if err := os.WriteFile(fileName, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testWatcher is a Watcher whose apply function keeps the applied
+// configuration as the running one, like the router does
+type testWatcher struct {
+	*Watcher
+	fileName string
+
+	mu      sync.Mutex
+	running *ag.APIGatorRouter
+	applied int
+	reject  error
+}
+
+// newTestWatcher returns a testWatcher for a configuration file with the
+// 'content', which is already loaded and running
+func newTestWatcher(t *testing.T, content string) *testWatcher {
+	t.Helper()
+
+	fileName := filepath.Join(t.TempDir(), "config.ini")
+	writeFile(t, fileName, content)
+	router, err := LoadConfig(fileName, nil, false, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tw := &testWatcher{fileName: fileName, running: router}
+	tw.Watcher = NewWatcher(fileName, nil, true, zap.NewNop(), func(router *ag.APIGatorRouter) error {
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		if tw.reject != nil {
+			return tw.reject
+		}
+		tw.running = router
+		tw.applied++
+		return nil
+	})
+	tw.debounce = 10 * time.Millisecond
+	return tw
+}
+
+// runningTarget returns the name of the target of the running configuration
+// and the number of configurations applied
+func (tw *testWatcher) runningTarget() (string, int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.running.APIGatorTargets[0].Name, tw.applied
+}
+
+func TestWatcherReload(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		force       bool
+		reject      error
+		wantErr     bool
+		wantTarget  string
+		wantApplied int
+	}{
+		{name: "unchanged file", content: testConfig("ALPHA"), wantTarget: "ALPHA"},
+		{name: "forced reload of unchanged file", content: testConfig("ALPHA"), force: true, wantTarget: "ALPHA", wantApplied: 1},
+		{name: "changed file", content: testConfig("BETA"), wantTarget: "BETA", wantApplied: 1},
+		{name: "invalid file keeps the running configuration", content: "[router]\nscore_function = unknown\n", wantErr: true, wantTarget: "ALPHA"},
+		{name: "strict checks keep the running configuration", content: testConfig("BETA") + "unknown_key = 1\n", wantErr: true, wantTarget: "ALPHA"},
+		{name: "rejected by apply keeps the running configuration", content: testConfig("BETA"), reject: errors.New("restart required"), wantErr: true, wantTarget: "ALPHA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tw := newTestWatcher(t, testConfig("ALPHA"))
+			tw.reject = tt.reject
+			writeFile(t, tw.fileName, tt.content)
+
+			if err := tw.Reload(tt.force); (err != nil) != tt.wantErr {
+				t.Errorf("Reload = %v, wantErr %v", err, tt.wantErr)
+			}
+			if target, applied := tw.runningTarget(); target != tt.wantTarget || applied != tt.wantApplied {
+				t.Errorf("running target %s after %d configurations applied, want %s after %d", target, applied, tt.wantTarget, tt.wantApplied)
+			}
+		})
+	}
+}
+
+func TestWatcherReportsRejectedFileOnce(t *testing.T) {
+	tw := newTestWatcher(t, testConfig("ALPHA"))
+	writeFile(t, tw.fileName, "[router]\nscore_function = unknown\n")
+
+	if err := tw.Reload(false); err == nil {
+		t.Fatal("Reload of an invalid file succeeded")
+	}
+	if err := tw.Reload(false); err != nil {
+		t.Errorf("second Reload of the same invalid file = %v, want nil", err)
+	}
+	if target, _ := tw.runningTarget(); target != "ALPHA" {
+		t.Errorf("running target %s, want ALPHA", target)
+	}
+}
+
+func TestWatcherDebounce(t *testing.T) {
+	tw := newTestWatcher(t, testConfig("ALPHA"))
+	tw.debounce = 100 * time.Millisecond
+
+	// The file keeps changing for several debounce periods, and only its
+	// final content must be loaded
+	names := []string{"BETA", "GAMMA", "DELTA", "OMEGA"}
+	writeFile(t, tw.fileName, testConfig(names[0]))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, name := range names[1:] {
+			time.Sleep(tw.debounce / 5)
+			writeFile(t, tw.fileName, testConfig(name))
+		}
+	}()
+
+	start := time.Now()
+	if err := tw.Reload(false); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if elapsed := time.Since(start); elapsed < tw.debounce {
+		t.Errorf("Reload took %v, want at least the debounce time %v", elapsed, tw.debounce)
+	}
+	if target, applied := tw.runningTarget(); target != "OMEGA" || applied != 1 {
+		t.Errorf("running target %s after %d configurations applied, want OMEGA after 1", target, applied)
+	}
+}
+
+func TestWatcherRun(t *testing.T) {
+	tw := newTestWatcher(t, testConfig("ALPHA"))
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		tw.Run(ctx, 10*time.Millisecond)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	writeFile(t, tw.fileName, testConfig("BETA"))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		target, applied := tw.runningTarget()
+		if target == "BETA" && applied == 1 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("running target %s after %d configurations applied, want BETA after 1", target, applied)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,218 @@
+// Package metrics defines the Prometheus metrics of the APIGatorDoraRouter
+// about the requests forwarded to APIGator, the evaluation of their responses
+// and the Access Tokens
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "dora_router"
+
+	// StatusError is the status label of the requests to APIGator failed
+	// without a response (connection refused, timeout, cancellation...)
+	StatusError = "error"
+
+	// Winner labels of the hedged requests
+	winnerPrimary = "primary"
+	winnerHedge   = "hedge"
+
+	// Result labels of the token refreshes
+	resultSuccess = "success"
+	resultFailure = "failure"
+)
+
+// breakerStates are the values of the circuit breaker state gauge
+var breakerStates = map[string]float64{
+	"closed":    0,
+	"half-open": 1,
+	"open":      2,
+}
+
+var (
+	// requestDuration is the latency of every request sent to APIGator,
+	// including every attempt
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "apigator_request_duration_seconds",
+		Help:      "Latency of the requests sent to each APIGator target.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 40, 60},
+	}, []string{"target"})
+
+	// requestStatus counts the status codes returned by APIGator
+	requestStatus = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "apigator_requests_total",
+		Help:      "Requests sent to each APIGator target by response status code.",
+	}, []string{"target", "status"})
+
+	// requestRetries counts the attempts repeated after the first one
+	requestRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "apigator_retries_total",
+		Help:      "Requests to each APIGator target repeated after a failed attempt.",
+	}, []string{"target"})
+
+	// hedgedRequests counts the attempts also sent to an alternate host, by
+	// the request which answered first
+	hedgedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "apigator_hedged_requests_total",
+		Help:      "Requests to each APIGator target hedged to an alternate host by winner.",
+	}, []string{"target", "winner"})
+
+	// tokenRefreshes counts the Access Token requests by result
+	tokenRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_refreshes_total",
+		Help:      "Access Token requests for each APIGator target by result.",
+	}, []string{"target", "result"})
+
+	// evaluationScore is the distribution of the scores given to the responses
+	evaluationScore = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "evaluation_score",
+		Help:      "Scores given by the evaluator to the responses of each APIGator target.",
+		Buckets:   prometheus.LinearBuckets(0, 0.1, 11),
+	}, []string{"target", "score_function"})
+
+	// unmodifiedResponses counts the responses discarded because APIGator
+	// returned the dataSet as it was sent
+	unmodifiedResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unmodified_responses_total",
+		Help:      "Responses of each APIGator target discarded for being equal to the request.",
+	}, []string{"target"})
+
+	// skippedTargets counts the requests not forwarded to a target
+	skippedTargets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "apigator_skipped_total",
+		Help:      "Requests not forwarded to each APIGator target by reason.",
+	}, []string{"target", "reason"})
+
+	// breakerState is the state of the circuit breaker of every target
+	breakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_breaker_state",
+		Help:      "State of the circuit breaker of each APIGator target (0 closed, 1 half-open, 2 open).",
+	}, []string{"target"})
+
+	// targetHealthy is the result of the last health check of every target
+	targetHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "apigator_healthy",
+		Help:      "Result of the last health check of each APIGator target (1 healthy, 0 unhealthy).",
+	}, []string{"target"})
+
+	// healthCheckLatency is the latency of the last health check of every target
+	healthCheckLatency = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "apigator_health_check_latency_seconds",
+		Help:      "Latency of the identity endpoint of each APIGator target on the last health check.",
+	}, []string{"target"})
+
+	// selectedTargets counts the responses returned to the requesters
+	selectedTargets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "selected_responses_total",
+		Help:      "Responses of each APIGator target selected for the requester.",
+	}, []string{"target"})
+
+	// auditFailures counts the routing decisions not served because their
+	// audit record couldn't be written
+	auditFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audit_write_failures_total",
+		Help:      "Routing decisions not served because their audit record couldn't be written.",
+	})
+)
+
+// Handler returns the HTTP handler exposing the metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveRequest records a request sent to APIGator. 'status' is the HTTP
+// status code of the response, or 0 if no response was received
+func ObserveRequest(target string, status int, duration time.Duration) {
+	label := StatusError
+	if status != 0 {
+		label = strconv.Itoa(status)
+	}
+	requestDuration.WithLabelValues(target).Observe(duration.Seconds())
+	requestStatus.WithLabelValues(target, label).Inc()
+}
+
+// ObserveRetry records a request to APIGator attempted again
+func ObserveRetry(target string) {
+	requestRetries.WithLabelValues(target).Inc()
+}
+
+// ObserveHedge records a hedged request and whether the alternate host
+// answered first
+func ObserveHedge(target string, hedgeWon bool) {
+	winner := winnerPrimary
+	if hedgeWon {
+		winner = winnerHedge
+	}
+	hedgedRequests.WithLabelValues(target, winner).Inc()
+}
+
+// ObserveTokenRefresh records an Access Token request and whether it failed
+func ObserveTokenRefresh(target string, err error) {
+	result := resultSuccess
+	if err != nil {
+		result = resultFailure
+	}
+	tokenRefreshes.WithLabelValues(target, result).Inc()
+}
+
+// ObserveScore records the score given to a response. Negative scores mean
+// invalid responses, so they're recorded on the lowest bucket
+func ObserveScore(target string, scoreFunction string, score float64) {
+	evaluationScore.WithLabelValues(target, scoreFunction).Observe(score)
+}
+
+// ObserveUnmodified records a response discarded for not being modified by APIGator
+func ObserveUnmodified(target string) {
+	unmodifiedResponses.WithLabelValues(target).Inc()
+}
+
+// ObserveSelected records the target whose response was returned to the requester
+func ObserveSelected(target string) {
+	selectedTargets.WithLabelValues(target).Inc()
+}
+
+// ObserveAuditFailure records a routing decision whose audit record couldn't
+// be written
+func ObserveAuditFailure() {
+	auditFailures.Inc()
+}
+
+// ObserveSkipped records a request not forwarded to a target
+func ObserveSkipped(target string, reason string) {
+	skippedTargets.WithLabelValues(target, reason).Inc()
+}
+
+// SetBreakerState publishes the state of the circuit breaker of a target
+func SetBreakerState(target string, state string) {
+	breakerState.WithLabelValues(target).Set(breakerStates[state])
+}
+
+// SetTargetHealth publishes the result of the last health check of a target
+func SetTargetHealth(target string, healthy bool, latency time.Duration) {
+	value := 0.0
+	if healthy {
+		value = 1.0
+	}
+	targetHealthy.WithLabelValues(target).Set(value)
+	healthCheckLatency.WithLabelValues(target).Set(latency.Seconds())
+}
@@ -0,0 +1,120 @@
+// Package secrets resolves the secret references used on the configuration
+// file, so credentials don't need to be defined as plain values on it
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// filePrefix references a secret stored on a file. The file is read again
+	// every time it changes. Example: "file:/var/run/secrets/alpha/secret"
+	filePrefix = "file:"
+	// envPrefix references a secret stored on an environment variable.
+	// Example: "env:ALPHA_SECRET"
+	envPrefix = "env:"
+	// execPrefix references a secret printed by a command on its standard
+	// output. Example: "exec:/usr/bin/helper alpha"
+	execPrefix = "exec:"
+
+	// execTimeout is the maximum time for a command to print a secret
+	execTimeout = 10 * time.Second
+)
+
+// Secret is a resolved secret reference. Values without any of the supported
+// prefixes are plain secrets
+type Secret struct {
+	ref string
+
+	mu      sync.Mutex
+	value   string
+	modTime time.Time
+	size    int64
+}
+
+// Resolve reads the secret referenced by 'ref'
+func Resolve(ref string) (*Secret, error) {
+	s := &Secret{ref: ref}
+
+	switch {
+	case strings.HasPrefix(ref, filePrefix):
+		if _, err := s.Value(); err != nil {
+			return nil, err
+		}
+	case strings.HasPrefix(ref, envPrefix):
+		name := strings.TrimPrefix(ref, envPrefix)
+		value, exists := os.LookupEnv(name)
+		if !exists {
+			return nil, fmt.Errorf("environment variable %q is not defined", name)
+		}
+		s.value = value
+	case strings.HasPrefix(ref, execPrefix):
+		value, err := runCommand(strings.TrimPrefix(ref, execPrefix))
+		if err != nil {
+			return nil, err
+		}
+		s.value = value
+	default:
+		s.value = ref
+	}
+
+	return s, nil
+}
+
+// Value returns the secret. Secrets stored on files are read again if the
+// file changed since the last read
+func (s *Secret) Value() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !strings.HasPrefix(s.ref, filePrefix) {
+		return s.value, nil
+	}
+
+	path := strings.TrimPrefix(s.ref, filePrefix)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %v", err)
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.value, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %v", err)
+	}
+	s.value = strings.TrimRight(string(content), "\r\n")
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return s.value, nil
+}
+
+// runCommand runs a command and returns its standard output without the
+// trailing new line
+func runCommand(command string) (string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return "", fmt.Errorf("empty secret command")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("secret command %q failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
@@ -0,0 +1,98 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secretFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SECRETS_TEST_SECRET", "from-env")
+	t.Setenv("SECRETS_TEST_EMPTY", "")
+
+	tests := []struct {
+		name    string
+		ref     string
+		want    string
+		wantErr string
+	}{
+		{name: "plain value", ref: "plain-secret", want: "plain-secret"},
+		{name: "empty plain value", ref: "", want: ""},
+		{name: "file", ref: "file:" + secretFile, want: "from-file"},
+		{name: "missing file", ref: "file:" + filepath.Join(dir, "missing"), wantErr: "failed to read secret file"},
+		{name: "directory", ref: "file:" + dir, wantErr: "failed to read secret file"},
+		{name: "env", ref: "env:SECRETS_TEST_SECRET", want: "from-env"},
+		{name: "empty env", ref: "env:SECRETS_TEST_EMPTY", want: ""},
+		{name: "missing env", ref: "env:SECRETS_TEST_MISSING", wantErr: `environment variable "SECRETS_TEST_MISSING" is not defined`},
+		{name: "exec", ref: "exec:echo from-exec", want: "from-exec"},
+		{name: "exec with arguments", ref: "exec:echo  from   exec ", want: "from exec"},
+		{name: "failing exec", ref: "exec:ls " + filepath.Join(dir, "missing"), wantErr: `secret command "ls" failed: exit status`},
+		{name: "unknown command", ref: "exec:secrets-test-unknown-command", wantErr: `secret command "secrets-test-unknown-command" failed`},
+		{name: "empty command", ref: "exec: ", wantErr: "empty secret command"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret, err := Resolve(tt.ref)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Resolve(%q) error = %v, want %q", tt.ref, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := secret.Value(); got != tt.want || err != nil {
+				t.Errorf("Value() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretFileRotation(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("first\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := Resolve("file:" + secretFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A rotated secret is used right away
+	if err := os.WriteFile(secretFile, []byte("rotated\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := secret.Value(); got != "rotated" || err != nil {
+		t.Errorf("Value() = %q, %v, want %q", got, err, "rotated")
+	}
+
+	// A removed secret file is an error, not the last value
+	if err := os.Remove(secretFile); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := secret.Value(); err == nil {
+		t.Errorf("Value() = %q after removing the file, want an error", got)
+	}
+}
+
+func TestSecretEnvResolvedOnce(t *testing.T) {
+	t.Setenv("SECRETS_TEST_SECRET", "first")
+	secret, err := Resolve("env:SECRETS_TEST_SECRET")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Environment and command secrets are only read on Resolve
+	t.Setenv("SECRETS_TEST_SECRET", "changed")
+	if got, err := secret.Value(); got != "first" || err != nil {
+		t.Errorf("Value() = %q, %v, want %q", got, err, "first")
+	}
+}
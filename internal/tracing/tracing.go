@@ -0,0 +1,168 @@
+// Package tracing configures the OpenTelemetry distributed tracing of the
+// APIGatorDoraRouter and provides the tracer used by the rest of packages
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// Supported span exporters
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+
+	// instrumentationName identifies the spans created by the router
+	instrumentationName = "exate-dora-router"
+	// defaultServiceName is the service name when 'service_name' is not configured
+	defaultServiceName = "apigator-dora-router"
+)
+
+// Config defines how the spans are exported. Configured on the "[tracing]"
+// INI section. Changes require a restart
+type Config struct {
+	// Span exporter: "none" (default), "otlp", "stdout" or "file"
+	Exporter string `ini:"exporter"`
+	// OTLP/HTTP collector address as host:port. Unset means the
+	// OTEL_EXPORTER_OTLP_* environment variables or their defaults
+	Endpoint string `ini:"endpoint"`
+	// Sends the OTLP spans over plain HTTP instead of HTTPS
+	Insecure bool `ini:"insecure"`
+	// File the spans are written to on "file" exporter
+	File string `ini:"file"`
+	// Name of the service on the spans
+	ServiceName string `ini:"service_name"`
+	// Fraction of the traces started by the router which are sampled, from
+	// 0.0 to 1.0. Traces started by the requester follow its sampling decision
+	SampleRatio float64 `ini:"sample_ratio"`
+}
+
+// DefaultConfig returns the Config with the default values
+func DefaultConfig() Config {
+	return Config{
+		Exporter:    ExporterNone,
+		ServiceName: defaultServiceName,
+		SampleRatio: 1.0,
+	}
+}
+
+// Validate checks the exporter and the sample ratio
+func (c Config) Validate() error {
+	switch c.Exporter {
+	case ExporterNone, ExporterOTLP, ExporterStdout:
+	case ExporterFile:
+		if c.File == "" {
+			return fmt.Errorf("file is required by the %q exporter", ExporterFile)
+		}
+	default:
+		return fmt.Errorf("unknown exporter %q", c.Exporter)
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("sample_ratio must be between 0.0 and 1.0")
+	}
+	return nil
+}
+
+// Setup configures the global tracer provider and the W3C Trace Context
+// propagator. The propagator is always configured, so the 'traceparent' of
+// the requester reaches APIGator even when the spans are not exported. It
+// returns the function flushing the pending spans on shutdown
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if config.Exporter == ExporterNone {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, closeOutput, err := newExporter(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(config.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeOutput != nil {
+			closeOutput.Close()
+		}
+		return err
+	}, nil
+}
+
+// newExporter builds the span exporter of the configuration. For the "file"
+// exporter, it also returns the file to close on shutdown
+func newExporter(ctx context.Context, config Config) (sdktrace.SpanExporter, io.Closer, error) {
+	switch config.Exporter {
+	case ExporterOTLP:
+		var options []otlptracehttp.Option
+		if config.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(ctx, options...)
+		return exporter, nil, err
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		return exporter, nil, err
+	case ExporterFile:
+		file, err := os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open traces file: %v", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return exporter, file, nil
+	}
+	return nil, nil, fmt.Errorf("unknown exporter %q", config.Exporter)
+}
+
+// Start creates a span as a child of the span of the context, if any
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// Extract returns the context with the remote span defined on the
+// 'traceparent' header of an incoming request
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Inject adds the 'traceparent' header of the span of the context to an
+// outgoing request
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// End records the error (if any) on the span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}